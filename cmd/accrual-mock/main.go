@@ -0,0 +1,33 @@
+package main
+
+import (
+	"flag"
+	"os"
+
+	"github.com/wickedv43/yd-diploma/internal/accrualmock"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+)
+
+func main() {
+	var cfg accrualmock.Config
+
+	flag.StringVar(&cfg.RunAddress, "a", ":8081", "address and port to run server")
+	flag.IntVar(&cfg.RateLimit, "rate", 0, "requests per minute before 429, 0 is unlimited")
+	flag.Float64Var(&cfg.ErrorRate, "errors", 0, "probability of 500 answer")
+	flag.DurationVar(&cfg.Latency, "latency", 0, "max random delay before answer")
+	flag.BoolVar(&cfg.AutoRegister, "auto", true, "register every Luhn-valid order on first request")
+	flag.Uint64Var(&cfg.Seed, "seed", 1, "random seed")
+	flag.Parse()
+
+	runAddress := os.Getenv("RUN_ADDRESS")
+	if runAddress != "" {
+		cfg.RunAddress = runAddress
+	}
+
+	log, _ := logger.NewLogger(nil)
+
+	err := accrualmock.NewServer(cfg, log.Logger).Start()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -1,29 +1,116 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"os/signal"
 	"syscall"
 
+	"github.com/pkg/errors"
 	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/accrual"
 	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/keyring"
 	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/metrics"
+	"github.com/wickedv43/yd-diploma/internal/password"
 	"github.com/wickedv43/yd-diploma/internal/server"
 	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/throttle"
+	"github.com/wickedv43/yd-diploma/internal/tracing"
+	"github.com/wickedv43/yd-diploma/internal/worker"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrate(os.Args[2:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	// provide part
 	i := do.New()
 
 	do.Provide(i, server.NewServer)
 	do.Provide(i, config.NewConfig)
 	do.Provide(i, logger.NewLogger)
+	do.Provide(i, keyring.NewKeyring)
+	do.Provide(i, metrics.NewRegistry)
+	do.Provide(i, tracing.NewProvider)
 
 	//storage
-	do.Provide(i, storage.NewPostgresStorage)
+	do.Provide(i, password.NewManager)
+	do.Provide(i, storage.NewDataKeeper)
+	do.Provide(i, throttle.NewThrottler)
+
+	//accrual system
+	do.Provide(i, accrual.NewHTTPClient)
+
+	//workers
+	do.Provide(i, worker.NewPoller)
+	do.Provide(i, worker.NewAuditor)
+	do.Provide(i, worker.NewCleaner)
+
+	log := do.MustInvoke[*logger.Logger](i)
+
+	if err := start(i); err != nil {
+		log.Error(errors.Wrap(err, "start"))
+		if errs := i.Shutdown(); errs != nil {
+			log.Error(errs)
+		}
+		os.Exit(1)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	<-ctx.Done()
+	stop()
+
+	log.Info("shutting down...")
+
+	//in-flight requests are drained before workers and storage go down
+	failed := false
+	if err := do.Shutdown[*server.Server](i); err != nil {
+		log.Error(err)
+		failed = true
+	}
+	if errs := i.Shutdown(); errs != nil {
+		log.Error(errs)
+		failed = true
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// start builds services and runs them, a build error isn't a panic
+func start(i do.Injector) error {
+	poller, err := do.Invoke[*worker.Poller](i)
+	if err != nil {
+		return err
+	}
+
+	auditor, err := do.Invoke[*worker.Auditor](i)
+	if err != nil {
+		return err
+	}
+
+	cleaner, err := do.Invoke[*worker.Cleaner](i)
+	if err != nil {
+		return err
+	}
+
+	srv, err := do.Invoke[*server.Server](i)
+	if err != nil {
+		return err
+	}
 
-	do.MustInvoke[*logger.Logger](i)
-	do.MustInvoke[*server.Server](i).Start()
+	poller.Start()
+	auditor.Start()
+	cleaner.Start()
 
-	i.ShutdownOnSignals(syscall.SIGTERM, os.Interrupt)
+	return srv.Start()
 }
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+)
+
+const migrateUsage = `usage: gophermart migrate up|down [steps]|status [flags]`
+
+// migrate runs "gophermart migrate <command> [steps] [flags]",
+// flags are the same as for the server
+func migrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	command, args := args[0], args[1:]
+
+	steps := 1
+	if command == "down" && len(args) > 0 {
+		if n, err := strconv.Atoi(args[0]); err == nil {
+			if n <= 0 {
+				return errors.New(migrateUsage)
+			}
+			steps, args = n, args[1:]
+		}
+	}
+
+	//config parses os.Args
+	os.Args = append([]string{os.Args[0]}, args...)
+
+	i := do.New()
+	do.Provide(i, config.NewConfig)
+	do.Provide(i, logger.NewLogger)
+	do.Provide(i, storage.NewMigrator)
+
+	m, err := do.Invoke[*storage.Migrator](i)
+	if err != nil {
+		return errors.Wrap(err, "init migrator")
+	}
+	defer m.Close()
+
+	ctx := context.Background()
+
+	switch command {
+	case "up":
+		return m.Up(ctx)
+	case "down":
+		return m.Down(ctx, steps)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range statuses {
+			fmt.Println(s)
+		}
+		return nil
+	}
+
+	return errors.New(migrateUsage)
+}
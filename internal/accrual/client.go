@@ -0,0 +1,320 @@
+package accrual
+
+import (
+	"context"
+	"encoding/json"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/do/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/metrics"
+	"github.com/wickedv43/yd-diploma/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// accrual system statuses
+const (
+	StatusRegistered = "REGISTERED"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+)
+
+const (
+	// failureThreshold of GetOrder calls failed in a row makes client unhealthy
+	failureThreshold  = 5
+	maxRetries        = 3
+	backoffBase       = 100 * time.Millisecond
+	backoffMax        = 5 * time.Second
+	defaultRetryAfter = 60 * time.Second
+)
+
+var ErrNotRegistered = errors.New("order not registered in accrual system")
+
+// Order is an accrual system answer, Accrual is nil when there is no reward
+type Order struct {
+	Order   string           `json:"order"`
+	Status  string           `json:"status"`
+	Accrual *entities.Points `json:"accrual,omitempty"`
+}
+
+// UnmarshalJSON rounds accrual with RoundPoints, accrual system isn't bound
+// to hundredths and its precision must not keep an order unsettled
+func (o *Order) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Order   string       `json:"order"`
+		Status  string       `json:"status"`
+		Accrual *json.Number `json:"accrual"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*o = Order{Order: raw.Order, Status: raw.Status}
+
+	if raw.Accrual != nil {
+		accrual, err := entities.RoundPoints(raw.Accrual.String())
+		if err != nil {
+			return errors.Wrap(err, "accrual")
+		}
+		o.Accrual = &accrual
+	}
+
+	return nil
+}
+
+type Client interface {
+	// GetOrder returns ErrNotRegistered when accrual system doesn't know the order
+	GetOrder(context.Context, string) (Order, error)
+	// Stats returns count of responses by http status code
+	Stats() map[int]int64
+}
+
+type HTTPClient struct {
+	cfg    *config.Config
+	client *http.Client
+	log    *logrus.Entry
+
+	//unix nano time until all callers must wait
+	pausedUntil atomic.Int64
+	//GetOrder calls failed in a row after all retries
+	failures atomic.Int32
+
+	mu    sync.Mutex
+	stats map[int]int64
+
+	//requests by status code, "error" when there is no response
+	requests *prometheus.CounterVec
+
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+func NewHTTPClient(i do.Injector) (*HTTPClient, error) {
+	c, err := do.InvokeStruct[HTTPClient](i)
+	if err != nil {
+		return nil, errors.Wrap(err, "invoke struct error")
+	}
+
+	c.cfg = do.MustInvoke[*config.Config](i)
+	c.log = do.MustInvoke[*logger.Logger](i).WithField("component", "accrual")
+	c.client = &http.Client{Timeout: 10 * time.Second}
+	c.stats = make(map[int]int64)
+
+	c.requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "accrual",
+		Name:      "requests_total",
+		Help:      "Accrual system requests by response status code.",
+	}, []string{"code"})
+	do.MustInvoke[*metrics.Registry](i).MustRegister(c.requests)
+
+	tp := do.MustInvoke[*tracing.Provider](i)
+	c.tracer = tp.Tracer("github.com/wickedv43/yd-diploma/internal/accrual")
+	c.propagator = tp.Propagator()
+
+	return c, nil
+}
+
+func (c *HTTPClient) GetOrder(ctx context.Context, number string) (Order, error) {
+	ctx, span := c.tracer.Start(ctx, "accrual.GetOrder", trace.WithAttributes(attribute.String("order.number", number)))
+	defer span.End()
+
+	var lastErr error
+
+	for attempt := 0; ; {
+		//wait if somebody got 429
+		if err := c.waitPause(ctx); err != nil {
+			return Order{}, err
+		}
+
+		order, retryAfter, err := c.do(ctx, number)
+		if err == nil || errors.Is(err, ErrNotRegistered) {
+			c.failures.Store(0)
+			return order, err
+		}
+
+		//429 doesn't count as attempt, just pause everybody and try again
+		if retryAfter > 0 {
+			c.pause(retryAfter)
+			continue
+		}
+
+		lastErr = err
+		if ctx.Err() != nil {
+			break
+		}
+		if attempt >= maxRetries {
+			c.failures.Add(1)
+			break
+		}
+
+		//500 and transport errors, jittered backoff
+		if err = sleep(ctx, backoff(attempt)); err != nil {
+			return Order{}, err
+		}
+		attempt++
+	}
+
+	span.SetStatus(codes.Error, lastErr.Error())
+
+	return Order{}, errors.Wrapf(lastErr, "get order %s", number)
+}
+
+// HealthCheck fails while accrual system keeps failing, throttling by 429
+// is not a failure
+func (c *HTTPClient) HealthCheck() error {
+	if n := c.failures.Load(); n >= failureThreshold {
+		return errors.Errorf("%d accrual requests failed in a row", n)
+	}
+
+	return nil
+}
+
+func (c *HTTPClient) Stats() map[int]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make(map[int]int64, len(c.stats))
+	for code, count := range c.stats {
+		stats[code] = count
+	}
+
+	return stats
+}
+
+// do makes a single request, retryAfter is set on 429. Every attempt is a
+// span, trace context goes to accrual system in traceparent header
+func (c *HTTPClient) do(ctx context.Context, number string) (Order, time.Duration, error) {
+	url := strings.TrimRight(c.cfg.AccrualSystem.URL, "/") + "/api/orders/" + number
+
+	ctx, span := c.tracer.Start(ctx, "GET /api/orders/{number}",
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.HTTPRequestMethodGet, semconv.URLFull(url)),
+	)
+	defer span.End()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Order{}, 0, errors.Wrap(err, "new request")
+	}
+	c.propagator.Inject(ctx, propagation.HeaderCarrier(req.Header))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		c.requests.WithLabelValues("error").Inc()
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "transport error")
+		return Order{}, 0, errors.Wrap(err, "do request")
+	}
+	defer res.Body.Close()
+
+	c.count(res.StatusCode)
+
+	span.SetAttributes(semconv.HTTPResponseStatusCode(res.StatusCode))
+	if res.StatusCode >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, res.Status)
+	}
+
+	switch res.StatusCode {
+	case http.StatusOK:
+		var order Order
+		if err = json.NewDecoder(res.Body).Decode(&order); err != nil {
+			return Order{}, 0, errors.Wrap(err, "decode response")
+		}
+		return order, 0, nil
+	case http.StatusNoContent:
+		return Order{}, 0, ErrNotRegistered
+	case http.StatusTooManyRequests:
+		retryAfter := parseRetryAfter(res.Header.Get("Retry-After"))
+		return Order{}, retryAfter, errors.Errorf("too many requests, retry after %s", retryAfter)
+	default:
+		return Order{}, 0, errors.Errorf("unexpected status code %d", res.StatusCode)
+	}
+}
+
+func (c *HTTPClient) count(code int) {
+	c.mu.Lock()
+	c.stats[code]++
+	c.mu.Unlock()
+
+	c.requests.WithLabelValues(strconv.Itoa(code)).Inc()
+}
+
+// pause makes every caller wait until d passes
+func (c *HTTPClient) pause(d time.Duration) {
+	until := time.Now().Add(d).UnixNano()
+
+	for {
+		current := c.pausedUntil.Load()
+		if current >= until {
+			return
+		}
+		if c.pausedUntil.CompareAndSwap(current, until) {
+			c.log.Warnf("accrual system throttled us, pause for %s", d)
+			return
+		}
+	}
+}
+
+func (c *HTTPClient) waitPause(ctx context.Context) error {
+	for {
+		wait := time.Until(time.Unix(0, c.pausedUntil.Load()))
+		if wait <= 0 {
+			return ctx.Err()
+		}
+
+		if err := sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+// parseRetryAfter supports both seconds and http date
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+
+	return defaultRetryAfter
+}
+
+// backoff is exponential with full jitter
+func backoff(attempt int) time.Duration {
+	d := min(backoffBase<<attempt, backoffMax)
+
+	return time.Duration(rand.Int64N(int64(d))) + time.Millisecond
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
@@ -0,0 +1,315 @@
+package accrual
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/metrics"
+	"github.com/wickedv43/yd-diploma/internal/tracing"
+)
+
+// newTestClient is a client of accrual system served by handler
+func newTestClient(t *testing.T, handler http.HandlerFunc) *HTTPClient {
+	t.Helper()
+
+	accrualSystem := httptest.NewServer(handler)
+	t.Cleanup(accrualSystem.Close)
+
+	cfg := &config.Config{}
+	cfg.AccrualSystem.URL = accrualSystem.URL
+
+	i := do.New()
+	t.Cleanup(func() {
+		_ = i.Shutdown()
+	})
+
+	do.ProvideValue(i, cfg)
+	do.Provide(i, func(do.Injector) (*logger.Logger, error) {
+		l, err := logger.NewLogger(nil)
+		if err != nil {
+			return nil, err
+		}
+		l.SetOutput(io.Discard)
+		return l, nil
+	})
+	do.Provide(i, metrics.NewRegistry)
+	do.Provide(i, tracing.NewProvider)
+
+	c, err := NewHTTPClient(i)
+	if err != nil {
+		t.Fatalf("new client: %v", err)
+	}
+
+	return c
+}
+
+func TestGetOrderAccrualRounding(t *testing.T) {
+	tests := []struct {
+		body string
+		want entities.Points
+		none bool
+	}{
+		{body: `{"order":"79927398713","status":"PROCESSED","accrual":729.98}`, want: 72998},
+		{body: `{"order":"79927398713","status":"PROCESSED","accrual":729.985}`, want: 72998},
+		{body: `{"order":"79927398713","status":"PROCESSED","accrual":729.995}`, want: 73000},
+		{body: `{"order":"79927398713","status":"PROCESSED","accrual":7.2998e2}`, want: 72998},
+		{body: `{"order":"79927398713","status":"PROCESSED","accrual":"0.125"}`, want: 12},
+		{body: `{"order":"79927398713","status":"INVALID"}`, none: true},
+		{body: `{"order":"79927398713","status":"PROCESSED","accrual":null}`, none: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.body, func(t *testing.T) {
+			c := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = io.WriteString(w, tt.body)
+			})
+
+			order, err := c.GetOrder(context.Background(), "79927398713")
+			if err != nil {
+				t.Fatalf("get order: %v", err)
+			}
+
+			switch {
+			case tt.none && order.Accrual != nil:
+				t.Errorf("accrual is %s, want none", order.Accrual)
+			case !tt.none && (order.Accrual == nil || *order.Accrual != tt.want):
+				t.Errorf("accrual is %v, want %s", order.Accrual, tt.want)
+			}
+		})
+	}
+}
+
+func TestGetOrderRetries(t *testing.T) {
+	tests := []struct {
+		name string
+		//status codes of consecutive answers, the last one repeats
+		statuses []int
+		body     string
+		err      error
+		failed   bool
+		hits     int32
+	}{
+		{
+			name:     "5xx is retried up to the limit",
+			statuses: []int{http.StatusInternalServerError},
+			failed:   true,
+			hits:     maxRetries + 1,
+		},
+		{
+			name:     "5xx then answer",
+			statuses: []int{http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK},
+			body:     `{"order":"79927398713","status":"PROCESSED","accrual":10}`,
+			hits:     3,
+		},
+		{
+			name:     "204 is not registered",
+			statuses: []int{http.StatusNoContent},
+			err:      ErrNotRegistered,
+			hits:     1,
+		},
+		{
+			name:     "malformed body is not retried forever",
+			statuses: []int{http.StatusOK},
+			body:     `{"order":"79927398713","status":`,
+			failed:   true,
+			hits:     maxRetries + 1,
+		},
+		{
+			name:     "unexpected status",
+			statuses: []int{http.StatusNotFound},
+			failed:   true,
+			hits:     maxRetries + 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits atomic.Int32
+
+			c := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+				n := int(hits.Add(1)) - 1
+				status := tt.statuses[min(n, len(tt.statuses)-1)]
+
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(status)
+				if status == http.StatusOK {
+					_, _ = io.WriteString(w, tt.body)
+				}
+			})
+
+			_, err := c.GetOrder(context.Background(), "79927398713")
+			switch {
+			case tt.err != nil:
+				if !errors.Is(err, tt.err) {
+					t.Errorf("error is %v, want %v", err, tt.err)
+				}
+			case tt.failed:
+				if err == nil {
+					t.Error("no error")
+				}
+			case err != nil:
+				t.Errorf("get order: %v", err)
+			}
+
+			if got := hits.Load(); got != tt.hits {
+				t.Errorf("accrual system got %d requests, want %d", got, tt.hits)
+			}
+
+			//one failed call isn't enough to be unhealthy
+			if err = c.HealthCheck(); err != nil {
+				t.Errorf("health check: %v", err)
+			}
+		})
+	}
+}
+
+func TestGetOrderUnhealthy(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+
+	//calls are failed without waiting for retries
+	for n := 0; n < failureThreshold; n++ {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _ = c.GetOrder(ctx, "79927398713")
+	}
+	if err := c.HealthCheck(); err != nil {
+		t.Fatalf("cancelled calls make client unhealthy: %v", err)
+	}
+
+	c.failures.Store(failureThreshold - 1)
+	if _, err := c.GetOrder(context.Background(), "79927398713"); err == nil {
+		t.Fatal("no error")
+	}
+	if err := c.HealthCheck(); err == nil {
+		t.Error("client is healthy after failures in a row")
+	}
+}
+
+func TestRetryAfterPausesEveryCaller(t *testing.T) {
+	var (
+		hits int32
+		mu   sync.Mutex
+		//arrival of answered requests
+		answered []time.Time
+	)
+
+	c := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		hits++
+		if hits == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+
+		answered = append(answered, time.Now())
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = io.WriteString(w, `{"order":"79927398713","status":"PROCESSING"}`)
+	})
+
+	var wg sync.WaitGroup
+	get := func() {
+		defer wg.Done()
+		if _, err := c.GetOrder(context.Background(), "79927398713"); err != nil {
+			t.Errorf("get order: %v", err)
+		}
+	}
+
+	wg.Add(1)
+	go get()
+
+	//the others come when the first caller is throttled
+	deadline := time.Now().Add(5 * time.Second)
+	for c.pausedUntil.Load() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("client isn't paused by 429")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	until := time.Unix(0, c.pausedUntil.Load())
+
+	wg.Add(2)
+	go get()
+	go get()
+	wg.Wait()
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	//429 isn't an attempt, every caller gets the answer
+	if hits != 4 {
+		t.Errorf("accrual system got %d requests, want 4", hits)
+	}
+	for _, at := range answered {
+		if at.Before(until) {
+			t.Errorf("request came %s before pause ended", until.Sub(at))
+		}
+	}
+
+	if err := c.HealthCheck(); err != nil {
+		t.Errorf("throttled client is unhealthy: %v", err)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  time.Duration
+		//http date is compared with now
+		approx bool
+	}{
+		{name: "seconds", value: "120", want: 2 * time.Minute},
+		{name: "http date", value: time.Now().Add(30 * time.Second).UTC().Format(http.TimeFormat), want: 30 * time.Second, approx: true},
+		{name: "past http date", value: "Wed, 21 Oct 2015 07:28:00 GMT", want: defaultRetryAfter},
+		{name: "zero", value: "0", want: defaultRetryAfter},
+		{name: "negative", value: "-5", want: defaultRetryAfter},
+		{name: "missing", value: "", want: defaultRetryAfter},
+		{name: "garbage", value: "soon", want: defaultRetryAfter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseRetryAfter(tt.value)
+			if tt.approx {
+				if got <= tt.want-2*time.Second || got > tt.want {
+					t.Errorf("got %s, want about %s", got, tt.want)
+				}
+				return
+			}
+
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	for attempt := 0; attempt < 10; attempt++ {
+		limit := min(backoffBase<<attempt, backoffMax) + time.Millisecond
+
+		for n := 0; n < 100; n++ {
+			if d := backoff(attempt); d <= 0 || d > limit {
+				t.Fatalf("backoff of attempt %d is %s, want up to %s", attempt, d, limit)
+			}
+		}
+	}
+}
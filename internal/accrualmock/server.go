@@ -0,0 +1,253 @@
+package accrualmock
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/accrual"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/util"
+)
+
+type Config struct {
+	RunAddress string
+	// RateLimit is a number of requests per minute, 0 means unlimited
+	RateLimit int
+	// ErrorRate is a probability of 500 answer
+	ErrorRate float64
+	// Latency is a max random delay before answer
+	Latency time.Duration
+	// AutoRegister makes every Luhn-valid unknown order registered
+	AutoRegister bool
+	Seed         uint64
+}
+
+// order is a scripted progression, every request moves it one step forward
+type order struct {
+	statuses []string
+	step     int
+	// nil means deterministic reward
+	accrual *entities.Points
+}
+
+type Server struct {
+	echo *echo.Echo
+	cfg  Config
+	log  *logrus.Entry
+
+	mu       sync.Mutex
+	rnd      *rand.Rand
+	orders   map[string]*order
+	window   time.Time
+	requests int
+	// all requests get 429 until this moment
+	throttledUntil time.Time
+}
+
+type registerRequest struct {
+	Order    string   `json:"order"`
+	Statuses []string `json:"statuses"`
+	// Accrual is optional, deterministic amount is used when it is empty
+	Accrual *entities.Points `json:"accrual"`
+}
+
+type throttleRequest struct {
+	Seconds int `json:"seconds"`
+}
+
+type orderResponse struct {
+	Order   string           `json:"order"`
+	Status  string           `json:"status"`
+	Accrual *entities.Points `json:"accrual,omitempty"`
+}
+
+var defaultStatuses = []string{accrual.StatusRegistered, accrual.StatusProcessing, accrual.StatusProcessed}
+
+func NewServer(cfg Config, log *logrus.Logger) *Server {
+	s := &Server{
+		echo:   echo.New(),
+		cfg:    cfg,
+		log:    log.WithField("component", "accrual-mock"),
+		rnd:    rand.New(rand.NewPCG(cfg.Seed, cfg.Seed)),
+		orders: make(map[string]*order),
+	}
+
+	s.echo.HideBanner = true
+	s.echo.Use(middleware.Recover())
+
+	s.echo.GET(`/api/orders/:number`, s.onGetOrder)
+
+	//admin
+	s.echo.POST(`/admin/orders`, s.onRegisterOrder)
+	s.echo.POST(`/admin/throttle`, s.onThrottle)
+
+	return s
+}
+
+func (s *Server) Start() error {
+	s.log.Infof("accrual mock started on %s...", s.cfg.RunAddress)
+	return s.echo.Start(s.cfg.RunAddress)
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.echo
+}
+
+func (s *Server) onGetOrder(c echo.Context) error {
+	number := c.Param("number")
+
+	//latency injection
+	if s.cfg.Latency > 0 {
+		time.Sleep(s.randDuration(s.cfg.Latency))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	//forced or rate limited
+	if retryAfter := s.throttle(time.Now()); retryAfter > 0 {
+		c.Response().Header().Set("Retry-After", strconv.Itoa(retryAfter))
+		return c.String(http.StatusTooManyRequests, fmt.Sprintf("No more than %d requests per minute allowed", s.cfg.RateLimit))
+	}
+
+	//random fails
+	if s.cfg.ErrorRate > 0 && s.rnd.Float64() < s.cfg.ErrorRate {
+		return c.String(http.StatusInternalServerError, "Internal Server Error")
+	}
+
+	o, ok := s.orders[number]
+	if !ok {
+		if !s.cfg.AutoRegister || !util.LuhnCheck(number) {
+			return c.NoContent(http.StatusNoContent)
+		}
+
+		o = &order{statuses: defaultStatuses}
+		s.orders[number] = o
+	}
+
+	status := o.statuses[o.step]
+	if o.step < len(o.statuses)-1 {
+		o.step++
+	}
+
+	resp := orderResponse{
+		Order:  number,
+		Status: status,
+	}
+
+	if status == accrual.StatusProcessed {
+		amount := deterministicAccrual(number)
+		if o.accrual != nil {
+			amount = *o.accrual
+		}
+
+		//zero reward means no accrual field
+		if amount > 0 {
+			resp.Accrual = &amount
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) onRegisterOrder(c echo.Context) error {
+	var req registerRequest
+
+	if err := c.Bind(&req); err != nil || req.Order == "" {
+		return c.JSON(http.StatusBadRequest, "Bad Request")
+	}
+
+	statuses := req.Statuses
+	if len(statuses) == 0 {
+		statuses = defaultStatuses
+	}
+
+	for _, status := range statuses {
+		switch status {
+		case accrual.StatusRegistered, accrual.StatusProcessing, accrual.StatusInvalid, accrual.StatusProcessed:
+		default:
+			return c.JSON(http.StatusBadRequest, fmt.Sprintf("unknown status %q", status))
+		}
+	}
+
+	o := &order{statuses: statuses}
+	if req.Accrual != nil {
+		if *req.Accrual < 0 {
+			return c.JSON(http.StatusBadRequest, "negative accrual")
+		}
+		o.accrual = req.Accrual
+	}
+
+	s.mu.Lock()
+	s.orders[req.Order] = o
+	s.mu.Unlock()
+
+	s.log.Infof("order %s registered with %v", req.Order, statuses)
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func (s *Server) onThrottle(c echo.Context) error {
+	var req throttleRequest
+
+	if err := c.Bind(&req); err != nil || req.Seconds < 0 {
+		return c.JSON(http.StatusBadRequest, "Bad Request")
+	}
+
+	s.mu.Lock()
+	s.throttledUntil = time.Now().Add(time.Duration(req.Seconds) * time.Second)
+	s.mu.Unlock()
+
+	return c.NoContent(http.StatusOK)
+}
+
+// throttle returns seconds to wait or 0 if request is allowed, s.mu must be held
+func (s *Server) throttle(now time.Time) int {
+	if now.Before(s.throttledUntil) {
+		return secondsUntil(now, s.throttledUntil)
+	}
+
+	if s.cfg.RateLimit <= 0 {
+		return 0
+	}
+
+	//fixed one minute window
+	if now.Sub(s.window) >= time.Minute {
+		s.window = now
+		s.requests = 0
+	}
+
+	if s.requests >= s.cfg.RateLimit {
+		return secondsUntil(now, s.window.Add(time.Minute))
+	}
+	s.requests++
+
+	return 0
+}
+
+func (s *Server) randDuration(limit time.Duration) time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return time.Duration(s.rnd.Int64N(int64(limit)))
+}
+
+// deterministicAccrual derives reward up to 999.99 from order number
+func deterministicAccrual(number string) entities.Points {
+	h := fnv.New32a()
+	h.Write([]byte(number))
+
+	return entities.Points(h.Sum32() % 100000)
+}
+
+func secondsUntil(now, t time.Time) int {
+	return int(t.Sub(now)/time.Second) + 1
+}
@@ -3,8 +3,11 @@ package config
 import (
 	"flag"
 	"os"
+	"strconv"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/pkg/errors"
 	"github.com/samber/do/v2"
 	"github.com/sirupsen/logrus"
 	"github.com/wickedv43/yd-diploma/internal/logger"
@@ -14,20 +17,83 @@ type Config struct {
 	Server        Server
 	AccrualSystem AccrualSystem
 	Database      Database
+	Ledger        Ledger
+	Idempotency   Idempotency
+	Auth          Auth
+	Cleanup       Cleanup
+	Throttle      Throttle
+	Admin         Admin
+	Account       Account
+	Tracing       Tracing
 
 	log *logrus.Entry
 }
 
 type Server struct {
 	RunAddress string
+	// ShutdownTimeout is how long in-flight requests are drained on stop
+	ShutdownTimeout time.Duration
+	// TrustedProxies are comma separated CIDRs allowed to set
+	// X-Forwarded-For, client address is used when empty
+	TrustedProxies string
 }
 
 type Database struct {
 	DSN string
 }
 
+type Auth struct {
+	PasswordHasher string
+	JWTSecret      string
+	JWTKeysFile    string
+	AccessTTL      time.Duration
+	RefreshTTL     time.Duration
+}
+
+type Idempotency struct {
+	TTL time.Duration
+}
+
+// Throttle is login brute-force protection
+type Throttle struct {
+	Store         string
+	LoginAttempts int
+	IPAttempts    int
+	BaseDelay     time.Duration
+	MaxLockout    time.Duration
+	Window        time.Duration
+}
+
+type Account struct {
+	// DeletePolicy is anonymize or cascade
+	DeletePolicy string
+}
+
+type Admin struct {
+	// Login is promoted to admin on start
+	Login string
+}
+
+// Tracing exporter is otlp, stdout, memory for tests or none. Headers and
+// so on of OTLP are read by the exporter from standard OTEL_EXPORTER_OTLP_* env
+type Tracing struct {
+	Exporter     string
+	OTLPEndpoint string
+	SampleRatio  float64
+}
+
+type Cleanup struct {
+	Interval time.Duration
+}
+
+type Ledger struct {
+	CheckInterval time.Duration
+}
+
 type AccrualSystem struct {
-	URL string
+	URL          string
+	PollInterval time.Duration
+	Workers      int
 }
 
 func NewConfig(i do.Injector) (*Config, error) {
@@ -37,8 +103,30 @@ func NewConfig(i do.Injector) (*Config, error) {
 
 	//flags
 	flag.StringVar(&cfg.Server.RunAddress, "a", ":8080", "address and port to run server")
+	flag.DurationVar(&cfg.Server.ShutdownTimeout, "shutdown-timeout", 10*time.Second, "in-flight requests drain timeout on shutdown")
+	flag.StringVar(&cfg.Server.TrustedProxies, "trusted-proxies", "", "comma separated CIDRs of proxies trusted to set X-Forwarded-For")
 	flag.StringVar(&cfg.Database.DSN, "d", "", "DSN")
 	flag.StringVar(&cfg.AccrualSystem.URL, "r", "", "accrual system url")
+	flag.DurationVar(&cfg.AccrualSystem.PollInterval, "p", time.Second, "accrual system poll interval")
+	flag.IntVar(&cfg.AccrualSystem.Workers, "w", 4, "accrual system workers count")
+	flag.DurationVar(&cfg.Ledger.CheckInterval, "ledger-check", time.Hour, "ledger consistency check interval, 0 disables it")
+	flag.DurationVar(&cfg.Idempotency.TTL, "idempotency-ttl", 24*time.Hour, "idempotency keys lifetime")
+	flag.DurationVar(&cfg.Cleanup.Interval, "cleanup-interval", time.Hour, "expired idempotency keys and tokens cleanup interval")
+	flag.StringVar(&cfg.Auth.PasswordHasher, "password-hasher", "argon2id", "password hash algorithm: argon2id or bcrypt")
+	flag.StringVar(&cfg.Auth.JWTKeysFile, "jwt-keys", "", "JWT signing keys file")
+	flag.DurationVar(&cfg.Auth.AccessTTL, "access-ttl", 15*time.Minute, "access token lifetime")
+	flag.DurationVar(&cfg.Auth.RefreshTTL, "refresh-ttl", 30*24*time.Hour, "refresh token lifetime")
+	flag.StringVar(&cfg.Throttle.Store, "throttle-store", "postgres", "failed logins store: postgres or memory")
+	flag.IntVar(&cfg.Throttle.LoginAttempts, "throttle-login-attempts", 5, "failed logins per account before lockout")
+	flag.IntVar(&cfg.Throttle.IPAttempts, "throttle-ip-attempts", 20, "failed logins per client IP before lockout")
+	flag.DurationVar(&cfg.Throttle.BaseDelay, "throttle-delay", time.Second, "first lockout duration, doubled with every failure")
+	flag.DurationVar(&cfg.Throttle.MaxLockout, "throttle-max-lockout", 15*time.Minute, "max lockout duration")
+	flag.DurationVar(&cfg.Throttle.Window, "throttle-window", 15*time.Minute, "failed logins are forgotten after this period")
+	flag.StringVar(&cfg.Admin.Login, "admin-login", "", "login promoted to admin on start")
+	flag.StringVar(&cfg.Account.DeletePolicy, "account-delete-policy", "anonymize", "deleted account data policy: anonymize keeps orders and withdrawals, cascade removes them")
+	flag.StringVar(&cfg.Tracing.Exporter, "trace-exporter", "none", "trace exporter: otlp, stdout, memory or none")
+	flag.StringVar(&cfg.Tracing.OTLPEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector url like http://localhost:4318, OTEL_EXPORTER_OTLP_* env is used when empty")
+	flag.Float64Var(&cfg.Tracing.SampleRatio, "trace-sample-ratio", 1, "share of traces started by this service that are sampled")
 	flag.Parse()
 
 	err := godotenv.Load()
@@ -52,6 +140,19 @@ func NewConfig(i do.Injector) (*Config, error) {
 		cfg.Server.RunAddress = runAdress
 	}
 
+	ShutdownTimeout := os.Getenv("SHUTDOWN_TIMEOUT")
+	if ShutdownTimeout != "" {
+		cfg.Server.ShutdownTimeout, err = time.ParseDuration(ShutdownTimeout)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse SHUTDOWN_TIMEOUT")
+		}
+	}
+
+	TrustedProxies := os.Getenv("TRUSTED_PROXIES")
+	if TrustedProxies != "" {
+		cfg.Server.TrustedProxies = TrustedProxies
+	}
+
 	DatabaseDSN := os.Getenv("DATABASE_DSN")
 	if DatabaseDSN != "" {
 		cfg.Database.DSN = DatabaseDSN
@@ -59,7 +160,108 @@ func NewConfig(i do.Injector) (*Config, error) {
 
 	AccrualSystemAddress := os.Getenv("ACCRUAL_SYSTEM_ADDRESS")
 	if AccrualSystemAddress != "" {
-		cfg.Database.DSN = AccrualSystemAddress
+		cfg.AccrualSystem.URL = AccrualSystemAddress
+	}
+
+	AccrualPollInterval := os.Getenv("ACCRUAL_POLL_INTERVAL")
+	if AccrualPollInterval != "" {
+		cfg.AccrualSystem.PollInterval, err = time.ParseDuration(AccrualPollInterval)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse ACCRUAL_POLL_INTERVAL")
+		}
+	}
+
+	AccrualWorkers := os.Getenv("ACCRUAL_WORKERS")
+	if AccrualWorkers != "" {
+		cfg.AccrualSystem.Workers, err = strconv.Atoi(AccrualWorkers)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse ACCRUAL_WORKERS")
+		}
+	}
+
+	LedgerCheckInterval := os.Getenv("LEDGER_CHECK_INTERVAL")
+	if LedgerCheckInterval != "" {
+		cfg.Ledger.CheckInterval, err = time.ParseDuration(LedgerCheckInterval)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse LEDGER_CHECK_INTERVAL")
+		}
+	}
+
+	IdempotencyTTL := os.Getenv("IDEMPOTENCY_TTL")
+	if IdempotencyTTL != "" {
+		cfg.Idempotency.TTL, err = time.ParseDuration(IdempotencyTTL)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse IDEMPOTENCY_TTL")
+		}
+	}
+
+	CleanupInterval := os.Getenv("CLEANUP_INTERVAL")
+	if CleanupInterval != "" {
+		cfg.Cleanup.Interval, err = time.ParseDuration(CleanupInterval)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse CLEANUP_INTERVAL")
+		}
+	}
+
+	PasswordHasher := os.Getenv("PASSWORD_HASHER")
+	if PasswordHasher != "" {
+		cfg.Auth.PasswordHasher = PasswordHasher
+	}
+
+	cfg.Auth.JWTSecret = os.Getenv("JWT_SECRET")
+
+	JWTKeysFile := os.Getenv("JWT_KEYS_FILE")
+	if JWTKeysFile != "" {
+		cfg.Auth.JWTKeysFile = JWTKeysFile
+	}
+
+	AccessTTL := os.Getenv("ACCESS_TOKEN_TTL")
+	if AccessTTL != "" {
+		cfg.Auth.AccessTTL, err = time.ParseDuration(AccessTTL)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse ACCESS_TOKEN_TTL")
+		}
+	}
+
+	RefreshTTL := os.Getenv("REFRESH_TOKEN_TTL")
+	if RefreshTTL != "" {
+		cfg.Auth.RefreshTTL, err = time.ParseDuration(RefreshTTL)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse REFRESH_TOKEN_TTL")
+		}
+	}
+
+	ThrottleStore := os.Getenv("THROTTLE_STORE")
+	if ThrottleStore != "" {
+		cfg.Throttle.Store = ThrottleStore
+	}
+
+	AccountDeletePolicy := os.Getenv("ACCOUNT_DELETE_POLICY")
+	if AccountDeletePolicy != "" {
+		cfg.Account.DeletePolicy = AccountDeletePolicy
+	}
+
+	AdminLogin := os.Getenv("ADMIN_LOGIN")
+	if AdminLogin != "" {
+		cfg.Admin.Login = AdminLogin
+	}
+
+	TraceExporter := os.Getenv("TRACE_EXPORTER")
+	if TraceExporter != "" {
+		cfg.Tracing.Exporter = TraceExporter
+	}
+
+	OTLPEndpoint := os.Getenv("OTLP_ENDPOINT")
+	if OTLPEndpoint != "" {
+		cfg.Tracing.OTLPEndpoint = OTLPEndpoint
+	}
+
+	TraceSampleRatio := os.Getenv("TRACE_SAMPLE_RATIO")
+	if TraceSampleRatio != "" {
+		cfg.Tracing.SampleRatio, err = strconv.ParseFloat(TraceSampleRatio, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, "parse TRACE_SAMPLE_RATIO")
+		}
 	}
 
 	return &cfg, nil
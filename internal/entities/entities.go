@@ -9,4 +9,5 @@ var (
 	ErrAlreadyExists   = errors.New("already exists")
 	ErrBadOrder        = errors.New("bad order")
 	ErrHaveEnoughMoney = errors.New("user have enough money to buy")
+	ErrTokenReused     = errors.New("refresh token reused")
 )
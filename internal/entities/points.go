@@ -0,0 +1,171 @@
+package entities
+
+import (
+	"database/sql/driver"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Points are loyalty points (1 point = 1 ruble) kept as an integer number of
+// hundredths, so sums never drift. User input with more than two fractional
+// digits is rejected, accrual system amounts are rounded by RoundPoints.
+type Points int64
+
+const pointsScale = 100
+
+// MaxPoints is the largest amount NUMERIC(14, 2) columns hold
+const MaxPoints Points = 999_999_999_999_99
+
+var ErrBadPoints = errors.New("bad points amount")
+
+// pointsPattern is a plain decimal, no exponent, fractions or hex
+var pointsPattern = regexp.MustCompile(`^-?\d+(\.\d{1,2})?$`)
+
+// ParsePoints reads a decimal like "729.98" or "751" without floats
+func ParsePoints(s string) (Points, error) {
+	s = strings.TrimSpace(s)
+	if !pointsPattern.MatchString(s) {
+		return 0, errors.Wrapf(ErrBadPoints, "parse %q", s)
+	}
+
+	//hundredths as a whole number: "729.9" is "72990"
+	whole, frac, _ := strings.Cut(s, ".")
+	frac += strings.Repeat("0", 2-len(frac))
+
+	n, err := strconv.ParseInt(whole+frac, 10, 64)
+	if err != nil {
+		return 0, errors.Wrapf(ErrBadPoints, "%q is out of range", s)
+	}
+
+	return Points(n), nil
+}
+
+// jsonNumberPattern is a JSON number, exponent is short enough to keep
+// big.Rat small
+var jsonNumberPattern = regexp.MustCompile(`^-?(0|[1-9]\d*)(\.\d+)?([eE][+-]?\d{1,3})?$`)
+
+// RoundPoints reads a JSON number of any precision like "729.985" or
+// "7.2998e2" and rounds it half to even to hundredths: 0.125 is 0.12 and
+// 0.135 is 0.14. Amounts beyond MaxPoints are rejected.
+func RoundPoints(s string) (Points, error) {
+	if !jsonNumberPattern.MatchString(s) {
+		return 0, errors.Wrapf(ErrBadPoints, "parse %q", s)
+	}
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return 0, errors.Wrapf(ErrBadPoints, "parse %q", s)
+	}
+
+	//hundredths are num*100/denom, denom is always positive
+	num := new(big.Int).Mul(r.Num(), big.NewInt(pointsScale))
+	q, m := new(big.Int).QuoRem(num, r.Denom(), new(big.Int))
+
+	//remainder against half of denom, ties go to even
+	half := new(big.Int).Abs(m)
+	half.Lsh(half, 1)
+	if c := half.Cmp(r.Denom()); c > 0 || c == 0 && q.Bit(0) == 1 {
+		q.Add(q, big.NewInt(int64(num.Sign())))
+	}
+
+	if q.CmpAbs(big.NewInt(int64(MaxPoints))) > 0 {
+		return 0, errors.Wrapf(ErrBadPoints, "%q is out of range", s)
+	}
+
+	return Points(q.Int64()), nil
+}
+
+// PointsFromInt makes points from a whole amount
+func PointsFromInt(n int64) Points {
+	return Points(n * pointsScale)
+}
+
+// Float is for metrics only, never use it for arithmetic
+func (p Points) Float() float64 {
+	return float64(p) / pointsScale
+}
+
+func (p Points) String() string {
+	sign := ""
+	v := uint64(p)
+	if p < 0 {
+		sign = "-"
+		v = uint64(-p)
+		if p == math.MinInt64 {
+			v = uint64(math.MaxInt64) + 1
+		}
+	}
+
+	whole, frac := v/pointsScale, v%pointsScale
+	if frac == 0 {
+		return sign + strconv.FormatUint(whole, 10)
+	}
+
+	fs := strings.TrimRight(strconv.FormatUint(frac+pointsScale, 10)[1:], "0")
+
+	return sign + strconv.FormatUint(whole, 10) + "." + fs
+}
+
+func (p Points) MarshalJSON() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+// UnmarshalJSON accepts both numbers and numeric strings
+func (p *Points) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		return nil
+	}
+
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+
+	v, err := ParsePoints(s)
+	if err != nil {
+		return err
+	}
+
+	*p = v
+
+	return nil
+}
+
+// Value stores points into NUMERIC column
+func (p Points) Value() (driver.Value, error) {
+	return p.String(), nil
+}
+
+// Scan reads points from NUMERIC column
+func (p *Points) Scan(src any) error {
+	switch v := src.(type) {
+	case []byte:
+		return p.scanString(string(v))
+	case string:
+		return p.scanString(v)
+	case int64:
+		*p = PointsFromInt(v)
+		return nil
+	case nil:
+		*p = 0
+		return nil
+	}
+
+	return errors.Wrapf(ErrBadPoints, "scan %T", src)
+}
+
+func (p *Points) scanString(s string) error {
+	v, err := ParsePoints(s)
+	if err != nil {
+		return err
+	}
+
+	*p = v
+
+	return nil
+}
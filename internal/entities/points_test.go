@@ -0,0 +1,128 @@
+package entities
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestParsePoints(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Points
+		err  bool
+	}{
+		{in: "751", want: 75100},
+		{in: "729.98", want: 72998},
+		{in: "729.9", want: 72990},
+		{in: " 0.01 ", want: 1},
+		{in: "-5.5", want: -550},
+		{in: "-0.05", want: -5},
+		{in: "92233720368547758.07", want: 9223372036854775807},
+		{in: "92233720368547758.08", err: true},
+		{in: "0.005", err: true},
+		{in: "1.999", err: true},
+		{in: "1/3", err: true},
+		{in: "0x10", err: true},
+		{in: "1.5e2", err: true},
+		{in: "+1", err: true},
+		{in: ".5", err: true},
+		{in: "5.", err: true},
+		{in: "", err: true},
+		{in: "NaN", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParsePoints(tt.in)
+			if tt.err {
+				if !errors.Is(err, ErrBadPoints) {
+					t.Fatalf("got %d and error %v, want ErrBadPoints", got, err)
+				}
+				return
+			}
+
+			if err != nil || got != tt.want {
+				t.Fatalf("got %d and error %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoundPoints(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Points
+		err  bool
+	}{
+		{in: "751", want: 75100},
+		{in: "729.98", want: 72998},
+		{in: "729.985", want: 72998},
+		{in: "729.975", want: 72998},
+		{in: "729.9851", want: 72999},
+		{in: "0.125", want: 12},
+		{in: "0.135", want: 14},
+		{in: "0.004", want: 0},
+		{in: "-0.125", want: -12},
+		{in: "-0.135", want: -14},
+		{in: "7.2998e2", want: 72998},
+		{in: "7.29985E+2", want: 72998},
+		{in: "1e-3", want: 0},
+		{in: "999999999999.99", want: MaxPoints},
+		{in: "999999999999.995", err: true},
+		{in: "1e100", err: true},
+		{in: "1e1000", err: true},
+		{in: "1/3", err: true},
+		{in: "0x10", err: true},
+		{in: "01", err: true},
+		{in: "+1", err: true},
+		{in: "Inf", err: true},
+		{in: "", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := RoundPoints(tt.in)
+			if tt.err {
+				if !errors.Is(err, ErrBadPoints) {
+					t.Fatalf("got %d and error %v, want ErrBadPoints", got, err)
+				}
+				return
+			}
+
+			if err != nil || got != tt.want {
+				t.Fatalf("got %d and error %v, want %d", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointsJSON(t *testing.T) {
+	var body struct {
+		Sum Points `json:"sum"`
+	}
+
+	for _, in := range []string{`{"sum":12.5}`, `{"sum":"12.50"}`} {
+		if err := json.Unmarshal([]byte(in), &body); err != nil || body.Sum != 1250 {
+			t.Errorf("%s: got %d and error %v", in, body.Sum, err)
+		}
+
+		out, _ := json.Marshal(body)
+		if string(out) != `{"sum":12.5}` {
+			t.Errorf("%s is marshaled to %s", in, out)
+		}
+	}
+
+	//user input is strict
+	for _, in := range []string{`{"sum":0.005}`, `{"sum":1.5e2}`, `{"sum":"null"}`, `{"sum":"\"12\""}`} {
+		if err := json.Unmarshal([]byte(in), &body); err == nil {
+			t.Errorf("%s is accepted", in)
+		}
+	}
+
+	body.Sum = 1250
+	if err := json.Unmarshal([]byte(`{"sum":null}`), &body); err != nil || body.Sum != 1250 {
+		t.Errorf("null: got %d and error %v", body.Sum, err)
+	}
+}
@@ -0,0 +1,299 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+)
+
+const defaultKeyID = "default"
+
+// minSecretLen is the HS256 key size, shorter secrets can be brute forced
+const minSecretLen = 32
+
+var (
+	ErrUnknownKey       = errors.New("unknown signing key")
+	ErrUnsupportedAlg   = errors.New("unsupported signing algorithm")
+	ErrNoActiveKey      = errors.New("active signing key is not set")
+	ErrVerificationOnly = errors.New("key can't sign")
+	ErrWeakSecret       = errors.New("HS256 secret must be at least 32 bytes")
+)
+
+// Key is a signing key, verify-only keys have no sign part
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Retired bool
+
+	sign   any
+	verify any
+}
+
+// Keyring signs tokens with the active key and verifies tokens signed
+// with any non-retired key, so keys can be rotated without logging users out
+type Keyring struct {
+	keys   map[string]*Key
+	active *Key
+	log    *logrus.Entry
+}
+
+// keyFile describes keys in JWT_KEYS_FILE
+type keyFile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		ID             string `json:"kid"`
+		Alg            string `json:"alg"`
+		Secret         string `json:"secret"`
+		PrivateKeyFile string `json:"private_key_file"`
+		PublicKeyFile  string `json:"public_key_file"`
+		Retired        bool   `json:"retired"`
+	} `json:"keys"`
+}
+
+func NewKeyring(i do.Injector) (*Keyring, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	k := &Keyring{
+		keys: make(map[string]*Key),
+		log:  do.MustInvoke[*logger.Logger](i).WithField("component", "keyring"),
+	}
+
+	switch {
+	case cfg.Auth.JWTKeysFile != "":
+		if err := k.load(cfg.Auth.JWTKeysFile); err != nil {
+			return nil, errors.Wrap(err, "load keys file")
+		}
+	case cfg.Auth.JWTSecret != "":
+		if err := checkSecret(cfg.Auth.JWTSecret); err != nil {
+			return nil, errors.Wrap(err, "JWT_SECRET")
+		}
+
+		k.add(&Key{
+			ID:     defaultKeyID,
+			Method: jwt.SigningMethodHS256,
+			sign:   []byte(cfg.Auth.JWTSecret),
+			verify: []byte(cfg.Auth.JWTSecret),
+		})
+		k.active = k.keys[defaultKeyID]
+	default:
+		//tokens die with the process
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, errors.Wrap(err, "generate secret")
+		}
+
+		k.log.Warn("JWT signing key is not configured, random secret is used")
+
+		k.add(&Key{
+			ID:     defaultKeyID,
+			Method: jwt.SigningMethodHS256,
+			sign:   secret,
+			verify: secret,
+		})
+		k.active = k.keys[defaultKeyID]
+	}
+
+	return k, nil
+}
+
+func (k *Keyring) add(key *Key) {
+	k.keys[key.ID] = key
+}
+
+func (k *Keyring) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "read file")
+	}
+
+	var f keyFile
+	if err = json.Unmarshal(data, &f); err != nil {
+		return errors.Wrap(err, "decode file")
+	}
+
+	for _, fk := range f.Keys {
+		key := &Key{
+			ID:      fk.ID,
+			Method:  jwt.GetSigningMethod(fk.Alg),
+			Retired: fk.Retired,
+		}
+
+		switch fk.Alg {
+		case jwt.SigningMethodHS256.Alg():
+			if err := checkSecret(fk.Secret); err != nil {
+				return errors.Wrapf(err, "key %s", fk.ID)
+			}
+			key.sign = []byte(fk.Secret)
+			key.verify = []byte(fk.Secret)
+		case jwt.SigningMethodRS256.Alg():
+			if fk.PrivateKeyFile != "" {
+				private, err := readPEM(fk.PrivateKeyFile, jwt.ParseRSAPrivateKeyFromPEM)
+				if err != nil {
+					return errors.Wrapf(err, "key %s", fk.ID)
+				}
+				key.sign, key.verify = private, &private.PublicKey
+			} else {
+				public, err := readPEM(fk.PublicKeyFile, jwt.ParseRSAPublicKeyFromPEM)
+				if err != nil {
+					return errors.Wrapf(err, "key %s", fk.ID)
+				}
+				key.verify = public
+			}
+		case jwt.SigningMethodEdDSA.Alg():
+			if fk.PrivateKeyFile != "" {
+				private, err := readPEM(fk.PrivateKeyFile, jwt.ParseEdPrivateKeyFromPEM)
+				if err != nil {
+					return errors.Wrapf(err, "key %s", fk.ID)
+				}
+				edPrivate, ok := private.(ed25519.PrivateKey)
+				if !ok {
+					return errors.Wrapf(ErrUnsupportedAlg, "key %s is not ed25519", fk.ID)
+				}
+				key.sign, key.verify = edPrivate, edPrivate.Public()
+			} else {
+				public, err := readPEM(fk.PublicKeyFile, jwt.ParseEdPublicKeyFromPEM)
+				if err != nil {
+					return errors.Wrapf(err, "key %s", fk.ID)
+				}
+				key.verify = public
+			}
+		default:
+			return errors.Wrapf(ErrUnsupportedAlg, "key %s: %q", fk.ID, fk.Alg)
+		}
+
+		k.add(key)
+	}
+
+	active, ok := k.keys[f.Active]
+	if !ok || active.Retired {
+		return ErrNoActiveKey
+	}
+	if active.sign == nil {
+		return errors.Wrapf(ErrVerificationOnly, "active key %s", active.ID)
+	}
+	k.active = active
+
+	return nil
+}
+
+func checkSecret(secret string) error {
+	if len(secret) < minSecretLen {
+		return ErrWeakSecret
+	}
+
+	return nil
+}
+
+func readPEM[T any](path string, parse func([]byte) (T, error)) (T, error) {
+	var key T
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return key, errors.Wrap(err, "read pem")
+	}
+
+	key, err = parse(data)
+	if err != nil {
+		return key, errors.Wrap(err, "parse pem")
+	}
+
+	return key, nil
+}
+
+// Sign issues token signed by the active key with kid header
+func (k *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(k.active.Method, claims)
+	token.Header["kid"] = k.active.ID
+
+	return token.SignedString(k.active.sign)
+}
+
+// Parse verifies token with the key from its kid header
+func (k *Keyring) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	return jwt.ParseWithClaims(tokenString, claims, k.keyfunc)
+}
+
+func (k *Keyring) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	key, ok := k.keys[kid]
+	if !ok || key.Retired {
+		return nil, errors.Wrapf(ErrUnknownKey, "kid %q", kid)
+	}
+
+	//no algorithm confusion
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.Wrapf(ErrUnsupportedAlg, "kid %q signed with %s", kid, token.Method.Alg())
+	}
+
+	return key.verify, nil
+}
+
+// JWK is a public key in RFC 7517 format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns public parts of non-retired asymmetric keys
+func (k *Keyring) JWKS() JWKS {
+	jwks := JWKS{Keys: []JWK{}}
+
+	for _, key := range k.keys {
+		if key.Retired {
+			continue
+		}
+
+		jwk := JWK{
+			Kid: key.ID,
+			Alg: key.Method.Alg(),
+			Use: "sig",
+		}
+
+		switch public := key.verify.(type) {
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			//symmetric keys are secret
+			continue
+		}
+
+		jwks.Keys = append(jwks.Keys, jwk)
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool {
+		return jwks.Keys[i].Kid < jwks.Keys[j].Kid
+	})
+
+	return jwks
+}
@@ -0,0 +1,382 @@
+package keyring
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+)
+
+func newKeyring(t *testing.T, cfg *config.Config) (*Keyring, error) {
+	t.Helper()
+
+	i := do.New()
+	do.ProvideValue(i, cfg)
+	do.Provide(i, func(do.Injector) (*logger.Logger, error) {
+		l, err := logger.NewLogger(nil)
+		if err != nil {
+			return nil, err
+		}
+		l.SetOutput(io.Discard)
+		return l, nil
+	})
+
+	return NewKeyring(i)
+}
+
+func TestHS256Secret(t *testing.T) {
+	strong := strings.Repeat("s", minSecretLen)
+	weak := strings.Repeat("s", minSecretLen-1)
+
+	keysFile := func(secret string) string {
+		path := filepath.Join(t.TempDir(), "keys.json")
+		data := `{"active":"k1","keys":[{"kid":"k1","alg":"HS256","secret":"` + secret + `"}]}`
+		if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+			t.Fatalf("write keys file: %v", err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name     string
+		secret   string
+		keysFile string
+		weak     bool
+	}{
+		{name: "strong JWT_SECRET", secret: strong},
+		{name: "short JWT_SECRET", secret: weak, weak: true},
+		{name: "strong secret in file", keysFile: keysFile(strong)},
+		{name: "short secret in file", keysFile: keysFile(weak), weak: true},
+		{name: "empty secret in file", keysFile: keysFile(""), weak: true},
+		{name: "random secret when nothing is set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Auth.JWTSecret = tt.secret
+			cfg.Auth.JWTKeysFile = tt.keysFile
+
+			k, err := newKeyring(t, cfg)
+			if tt.weak {
+				if !errors.Is(err, ErrWeakSecret) {
+					t.Fatalf("got error %v, want ErrWeakSecret", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("new keyring: %v", err)
+			}
+
+			if secret, _ := k.active.sign.([]byte); len(secret) < minSecretLen {
+				t.Errorf("active secret has %d bytes", len(secret))
+			}
+		})
+	}
+}
+
+var testSecret = strings.Repeat("s", minSecretLen)
+
+// testKeys are PEM files of one RSA and one Ed25519 key
+type testKeys struct {
+	dir string
+
+	rsa     *rsa.PrivateKey
+	ed      ed25519.PrivateKey
+	rsaPriv string
+	rsaPub  string
+	edPriv  string
+	edPub   string
+}
+
+func newTestKeys(t *testing.T) *testKeys {
+	t.Helper()
+
+	k := &testKeys{dir: t.TempDir()}
+
+	var err error
+	if k.rsa, err = rsa.GenerateKey(rand.Reader, 2048); err != nil {
+		t.Fatalf("generate rsa key: %v", err)
+	}
+	if _, k.ed, err = ed25519.GenerateKey(rand.Reader); err != nil {
+		t.Fatalf("generate ed25519 key: %v", err)
+	}
+
+	k.rsaPriv = k.writePEM(t, "rsa.pem", "PRIVATE KEY", k.rsa)
+	k.rsaPub = k.writePEM(t, "rsa.pub.pem", "PUBLIC KEY", &k.rsa.PublicKey)
+	k.edPriv = k.writePEM(t, "ed.pem", "PRIVATE KEY", k.ed)
+	k.edPub = k.writePEM(t, "ed.pub.pem", "PUBLIC KEY", k.ed.Public())
+
+	return k
+}
+
+func (k *testKeys) writePEM(t *testing.T, name, blockType string, key any) string {
+	t.Helper()
+
+	var (
+		der []byte
+		err error
+	)
+	if blockType == "PUBLIC KEY" {
+		der, err = x509.MarshalPKIXPublicKey(key)
+	} else {
+		der, err = x509.MarshalPKCS8PrivateKey(key)
+	}
+	if err != nil {
+		t.Fatalf("marshal %s: %v", name, err)
+	}
+
+	path := filepath.Join(k.dir, name)
+	if err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatalf("write %s: %v", name, err)
+	}
+
+	return path
+}
+
+// keyring loads keys file with content
+func (k *testKeys) keyring(t *testing.T, content string) (*Keyring, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatalf("write keys file: %v", err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Auth.JWTKeysFile = path
+
+	return newKeyring(t, cfg)
+}
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{
+		Subject:   "1",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	}
+}
+
+func TestRotation(t *testing.T) {
+	keys := newTestKeys(t)
+
+	//k1 is the only key
+	before, err := keys.keyring(t, `{"active":"k1","keys":[
+		{"kid":"k1","alg":"HS256","secret":"`+testSecret+`"}
+	]}`)
+	if err != nil {
+		t.Fatalf("keyring before rotation: %v", err)
+	}
+	old, err := before.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	//k2 is active, k1 still verifies
+	rotated, err := keys.keyring(t, `{"active":"k2","keys":[
+		{"kid":"k1","alg":"HS256","secret":"`+testSecret+`"},
+		{"kid":"k2","alg":"RS256","private_key_file":"`+keys.rsaPriv+`"}
+	]}`)
+	if err != nil {
+		t.Fatalf("keyring after rotation: %v", err)
+	}
+
+	if _, err = rotated.Parse(old, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("token of the previous key: %v", err)
+	}
+
+	fresh, err := rotated.Sign(testClaims())
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	token, err := rotated.Parse(fresh, &jwt.RegisteredClaims{})
+	if err != nil {
+		t.Fatalf("token of the active key: %v", err)
+	}
+	if token.Header["kid"] != "k2" || token.Method.Alg() != "RS256" {
+		t.Errorf("token is signed by %v with %s, want k2 with RS256", token.Header["kid"], token.Method.Alg())
+	}
+
+	//k1 is retired
+	retired, err := keys.keyring(t, `{"active":"k2","keys":[
+		{"kid":"k1","alg":"HS256","secret":"`+testSecret+`","retired":true},
+		{"kid":"k2","alg":"RS256","private_key_file":"`+keys.rsaPriv+`"}
+	]}`)
+	if err != nil {
+		t.Fatalf("keyring after retirement: %v", err)
+	}
+
+	if _, err = retired.Parse(old, &jwt.RegisteredClaims{}); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("token of the retired key: got error %v, want ErrUnknownKey", err)
+	}
+	if _, err = retired.Parse(fresh, &jwt.RegisteredClaims{}); err != nil {
+		t.Errorf("token of the active key: %v", err)
+	}
+
+	//retired key can't be active
+	_, err = keys.keyring(t, `{"active":"k1","keys":[
+		{"kid":"k1","alg":"HS256","secret":"`+testSecret+`","retired":true}
+	]}`)
+	if !errors.Is(err, ErrNoActiveKey) {
+		t.Errorf("retired active key: got error %v, want ErrNoActiveKey", err)
+	}
+}
+
+func TestAsymmetricKeys(t *testing.T) {
+	keys := newTestKeys(t)
+
+	tests := []struct {
+		alg     string
+		private string
+		public  string
+	}{
+		{alg: "RS256", private: keys.rsaPriv, public: keys.rsaPub},
+		{alg: "EdDSA", private: keys.edPriv, public: keys.edPub},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			signer, err := keys.keyring(t, `{"active":"k1","keys":[
+				{"kid":"k1","alg":"`+tt.alg+`","private_key_file":"`+tt.private+`"}
+			]}`)
+			if err != nil {
+				t.Fatalf("signing keyring: %v", err)
+			}
+
+			signed, err := signer.Sign(testClaims())
+			if err != nil {
+				t.Fatalf("sign: %v", err)
+			}
+
+			token, err := signer.Parse(signed, &jwt.RegisteredClaims{})
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			if token.Method.Alg() != tt.alg {
+				t.Errorf("token is signed with %s", token.Method.Alg())
+			}
+
+			//another replica with public key only verifies
+			verifier, err := keys.keyring(t, `{"active":"k0","keys":[
+				{"kid":"k0","alg":"HS256","secret":"`+testSecret+`"},
+				{"kid":"k1","alg":"`+tt.alg+`","public_key_file":"`+tt.public+`"}
+			]}`)
+			if err != nil {
+				t.Fatalf("verifying keyring: %v", err)
+			}
+			if _, err = verifier.Parse(signed, &jwt.RegisteredClaims{}); err != nil {
+				t.Errorf("verify with public key: %v", err)
+			}
+
+			_, err = keys.keyring(t, `{"active":"k1","keys":[
+				{"kid":"k1","alg":"`+tt.alg+`","public_key_file":"`+tt.public+`"}
+			]}`)
+			if !errors.Is(err, ErrVerificationOnly) {
+				t.Errorf("public key is active: got error %v, want ErrVerificationOnly", err)
+			}
+
+			//signature of another key
+			parts := strings.Split(signed, ".")
+			forged := parts[0] + "." + parts[1] + "." + base64.RawURLEncoding.EncodeToString([]byte("forged"))
+			if _, err = signer.Parse(forged, &jwt.RegisteredClaims{}); err == nil {
+				t.Error("forged signature is accepted")
+			}
+		})
+	}
+
+	//HMAC with public key as secret
+	t.Run("algorithm confusion", func(t *testing.T) {
+		k, err := keys.keyring(t, `{"active":"k1","keys":[
+			{"kid":"k1","alg":"RS256","private_key_file":"`+keys.rsaPriv+`"}
+		]}`)
+		if err != nil {
+			t.Fatalf("keyring: %v", err)
+		}
+
+		public, err := os.ReadFile(keys.rsaPub)
+		if err != nil {
+			t.Fatalf("read public key: %v", err)
+		}
+
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+		token.Header["kid"] = "k1"
+		forged, err := token.SignedString(public)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+
+		if _, err = k.Parse(forged, &jwt.RegisteredClaims{}); !errors.Is(err, ErrUnsupportedAlg) {
+			t.Errorf("got error %v, want ErrUnsupportedAlg", err)
+		}
+	})
+}
+
+func TestJWKS(t *testing.T) {
+	keys := newTestKeys(t)
+
+	k, err := keys.keyring(t, `{"active":"hs","keys":[
+		{"kid":"hs","alg":"HS256","secret":"`+testSecret+`"},
+		{"kid":"rs","alg":"RS256","private_key_file":"`+keys.rsaPriv+`"},
+		{"kid":"ed","alg":"EdDSA","private_key_file":"`+keys.edPriv+`"},
+		{"kid":"old","alg":"RS256","public_key_file":"`+keys.rsaPub+`","retired":true}
+	]}`)
+	if err != nil {
+		t.Fatalf("keyring: %v", err)
+	}
+
+	jwks := k.JWKS()
+
+	want := []JWK{
+		{
+			Kty: "OKP", Kid: "ed", Alg: "EdDSA", Use: "sig", Crv: "Ed25519",
+			X: base64.RawURLEncoding.EncodeToString(keys.ed.Public().(ed25519.PublicKey)),
+		},
+		{
+			Kty: "RSA", Kid: "rs", Alg: "RS256", Use: "sig",
+			N: base64.RawURLEncoding.EncodeToString(keys.rsa.N.Bytes()),
+			E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(keys.rsa.E)).Bytes()),
+		},
+	}
+	if len(jwks.Keys) != len(want) {
+		t.Fatalf("jwks has %d keys, want %d: %+v", len(jwks.Keys), len(want), jwks.Keys)
+	}
+	for n := range want {
+		if jwks.Keys[n] != want[n] {
+			t.Errorf("key %d is %+v, want %+v", n, jwks.Keys[n], want[n])
+		}
+	}
+
+	//nothing secret is published
+	data, err := json.Marshal(jwks)
+	if err != nil {
+		t.Fatalf("marshal jwks: %v", err)
+	}
+
+	body := string(data)
+	for _, secret := range []string{
+		testSecret,
+		base64.RawURLEncoding.EncodeToString([]byte(testSecret)),
+		base64.RawURLEncoding.EncodeToString(keys.rsa.D.Bytes()),
+		base64.RawURLEncoding.EncodeToString(keys.ed.Seed()),
+		`"d"`, `"k"`, `"oct"`,
+	} {
+		if strings.Contains(body, secret) {
+			t.Errorf("jwks contains %s", secret)
+		}
+	}
+}
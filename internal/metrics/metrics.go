@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/samber/do/v2"
+)
+
+// Namespace prefixes every metric of the service
+const Namespace = "gophermart"
+
+// Registry is shared through do, every component registers its own
+// collectors in its constructor
+type Registry struct {
+	*prometheus.Registry
+}
+
+func NewRegistry(_ do.Injector) (*Registry, error) {
+	r := prometheus.NewRegistry()
+
+	r.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+
+	return &Registry{r}, nil
+}
+
+// Handler serves metrics in prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return promhttp.HandlerFor(r.Registry, promhttp.HandlerOpts{Registry: r.Registry})
+}
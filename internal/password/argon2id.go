@@ -0,0 +1,119 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/argon2"
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// lengths of stored salt and key, RFC 9106 minimums
+const (
+	argon2idMinSaltLen = 8
+	argon2idMinKeyLen  = 4
+	argon2idMaxKeyLen  = 1024
+)
+
+var ErrBadHash = errors.New("bad password hash")
+
+// Argon2id uses PHC string format:
+// $argon2id$v=19$m=65536,t=1,p=4$<salt>$<key>
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+	KeyLen  uint32
+	SaltLen uint32
+
+	// caps of stored hash parameters, a bad row must not exhaust memory or
+	// CPU on every login
+	MaxTime    uint32
+	MaxMemory  uint32
+	MaxThreads uint8
+}
+
+// NewArgon2id returns hasher with RFC 9106 second recommended parameters
+func NewArgon2id() *Argon2id {
+	return &Argon2id{
+		Time:    3,
+		Memory:  64 * 1024,
+		Threads: 4,
+		KeyLen:  32,
+		SaltLen: 16,
+
+		MaxTime:    16,
+		MaxMemory:  256 * 1024,
+		MaxThreads: 16,
+	}
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", errors.Wrap(err, "generate salt")
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, a.Memory, a.Time, a.Threads,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *Argon2id) Verify(password, encoded string) (bool, bool, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 6 {
+		return false, false, ErrBadHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, false, ErrBadHash
+	}
+
+	var memory, time uint32
+	var threads uint8
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, false, ErrBadHash
+	}
+
+	//argon2 panics on zero threads, memory is at least 8 KiB per thread
+	if time < 1 || time > a.MaxTime ||
+		threads < 1 || threads > a.MaxThreads ||
+		memory < 8*uint32(threads) || memory > a.MaxMemory {
+		return false, false, errors.Wrapf(ErrBadHash, "parameters m=%d,t=%d,p=%d", memory, time, threads)
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil || len(salt) < argon2idMinSaltLen {
+		return false, false, ErrBadHash
+	}
+
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) < argon2idMinKeyLen || len(key) > argon2idMaxKeyLen {
+		return false, false, ErrBadHash
+	}
+
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(key, other) != 1 {
+		return false, false, nil
+	}
+
+	//parameters were changed since hashing
+	rehash := memory != a.Memory || time != a.Time || threads != a.Threads ||
+		uint32(len(key)) != a.KeyLen || uint32(len(salt)) != a.SaltLen
+
+	return true, rehash, nil
+}
+
+func (a *Argon2id) Match(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
@@ -0,0 +1,98 @@
+package password
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/config"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var ErrUnknownAlgorithm = errors.New("unknown password hash algorithm")
+
+type Hasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encoded hash and
+	// whether the hash should be replaced with a fresh one
+	Verify(password, encoded string) (ok bool, rehash bool, err error)
+	// Match reports whether encoded hash was made by this hasher
+	Match(encoded string) bool
+}
+
+// Manager hashes new passwords with the preferred hasher and verifies
+// hashes of every supported algorithm. Anything else is a legacy plaintext
+// password, it is compared in constant time and always needs a rehash.
+type Manager struct {
+	preferred Hasher
+	hashers   []Hasher
+	// dummy is verified when user doesn't exist to keep timing the same
+	dummy string
+}
+
+func NewManager(i do.Injector) (*Manager, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	return New(cfg.Auth.PasswordHasher)
+}
+
+func New(algorithm string) (*Manager, error) {
+	argon := NewArgon2id()
+	bcrypt := NewBcrypt()
+
+	m := &Manager{
+		hashers: []Hasher{argon, bcrypt},
+	}
+
+	switch strings.ToLower(algorithm) {
+	case "", AlgorithmArgon2id:
+		m.preferred = argon
+	case AlgorithmBcrypt:
+		m.preferred = bcrypt
+	default:
+		return nil, errors.Wrapf(ErrUnknownAlgorithm, "%q", algorithm)
+	}
+
+	dummy, err := m.preferred.Hash("dummy password")
+	if err != nil {
+		return nil, errors.Wrap(err, "hash dummy password")
+	}
+	m.dummy = dummy
+
+	return m, nil
+}
+
+func (m *Manager) Hash(password string) (string, error) {
+	return m.preferred.Hash(password)
+}
+
+func (m *Manager) Verify(password, encoded string) (bool, bool, error) {
+	for _, h := range m.hashers {
+		if !h.Match(encoded) {
+			continue
+		}
+
+		ok, rehash, err := h.Verify(password, encoded)
+		if err != nil || !ok {
+			return false, false, err
+		}
+
+		//algorithm changed
+		return true, rehash || h != m.preferred, nil
+	}
+
+	//legacy plaintext
+	ok := subtle.ConstantTimeCompare([]byte(password), []byte(encoded)) == 1
+
+	return ok, ok, nil
+}
+
+// VerifyDummy burns the same time as a real verification
+func (m *Manager) VerifyDummy(password string) {
+	_, _, _ = m.preferred.Verify(password, m.dummy)
+}
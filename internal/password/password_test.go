@@ -0,0 +1,175 @@
+package password
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+// testArgon2id is cheap enough for tests
+func testArgon2id() *Argon2id {
+	a := NewArgon2id()
+	a.Time = 1
+	a.Memory = 64
+	a.Threads = 1
+
+	return a
+}
+
+func TestRoundTrip(t *testing.T) {
+	hashers := map[string]Hasher{
+		AlgorithmArgon2id: testArgon2id(),
+		AlgorithmBcrypt:   &Bcrypt{Cost: 4},
+	}
+
+	for name, h := range hashers {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := h.Hash("correct horse")
+			if err != nil {
+				t.Fatalf("hash: %v", err)
+			}
+			if !h.Match(encoded) {
+				t.Fatalf("hasher doesn't match its hash %s", encoded)
+			}
+
+			ok, rehash, err := h.Verify("correct horse", encoded)
+			if err != nil || !ok || rehash {
+				t.Errorf("right password: ok %t, rehash %t, error %v", ok, rehash, err)
+			}
+
+			ok, _, err = h.Verify("wrong horse", encoded)
+			if err != nil || ok {
+				t.Errorf("wrong password: ok %t, error %v", ok, err)
+			}
+		})
+	}
+}
+
+func TestRehashOnParameterChange(t *testing.T) {
+	old := testArgon2id()
+	encoded, err := old.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(*Argon2id)
+	}{
+		{name: "time", change: func(a *Argon2id) { a.Time = 2 }},
+		{name: "memory", change: func(a *Argon2id) { a.Memory = 128 }},
+		{name: "threads", change: func(a *Argon2id) { a.Threads = 2 }},
+		{name: "key length", change: func(a *Argon2id) { a.KeyLen = 64 }},
+		{name: "salt length", change: func(a *Argon2id) { a.SaltLen = 32 }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current := testArgon2id()
+			tt.change(current)
+
+			ok, rehash, err := current.Verify("correct horse", encoded)
+			if err != nil || !ok || !rehash {
+				t.Errorf("ok %t, rehash %t, error %v, want rehash", ok, rehash, err)
+			}
+		})
+	}
+
+	t.Run("bcrypt cost", func(t *testing.T) {
+		encoded, err := (&Bcrypt{Cost: 4}).Hash("correct horse")
+		if err != nil {
+			t.Fatalf("hash: %v", err)
+		}
+
+		ok, rehash, err := (&Bcrypt{Cost: 5}).Verify("correct horse", encoded)
+		if err != nil || !ok || !rehash {
+			t.Errorf("ok %t, rehash %t, error %v, want rehash", ok, rehash, err)
+		}
+	})
+}
+
+func TestManagerUpgrade(t *testing.T) {
+	argon := testArgon2id()
+	m := &Manager{
+		preferred: argon,
+		hashers:   []Hasher{argon, &Bcrypt{Cost: 4}},
+	}
+
+	bcryptHash, err := (&Bcrypt{Cost: 4}).Hash("correct horse")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+	argonHash, err := m.Hash("correct horse")
+	if err != nil {
+		t.Fatalf("hash: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		encoded  string
+		ok       bool
+		rehash   bool
+	}{
+		{name: "preferred", password: "correct horse", encoded: argonHash, ok: true},
+		{name: "other algorithm", password: "correct horse", encoded: bcryptHash, ok: true, rehash: true},
+		{name: "legacy plaintext", password: "correct horse", encoded: "correct horse", ok: true, rehash: true},
+		{name: "wrong legacy plaintext", password: "wrong horse", encoded: "correct horse"},
+		{name: "legacy plaintext prefix", password: "correct", encoded: "correct horse"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := m.Verify(tt.password, tt.encoded)
+			if err != nil || ok != tt.ok || rehash != tt.rehash {
+				t.Errorf("ok %t, rehash %t, error %v, want ok %t, rehash %t", ok, rehash, err, tt.ok, tt.rehash)
+			}
+		})
+	}
+}
+
+func TestArgon2idBadHash(t *testing.T) {
+	const (
+		salt = "c29tZXNhbHRzb21lc2FsdA"
+		key  = "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	)
+
+	//well-formed hash of another password
+	ok, _, err := testArgon2id().Verify("correct horse", "$argon2id$v=19$m=64,t=1,p=1$"+salt+"$"+key)
+	if err != nil || ok {
+		t.Fatalf("well-formed hash: ok %t, error %v", ok, err)
+	}
+
+	tests := []struct {
+		name    string
+		encoded string
+	}{
+		{name: "too few parts", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt},
+		{name: "unknown version", encoded: "$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key},
+		{name: "no parameters", encoded: "$argon2id$v=19$$" + salt + "$" + key},
+		{name: "zero threads", encoded: "$argon2id$v=19$m=64,t=1,p=0$" + salt + "$" + key},
+		{name: "too many threads", encoded: "$argon2id$v=19$m=4096,t=1,p=255$" + salt + "$" + key},
+		{name: "threads overflow", encoded: "$argon2id$v=19$m=64,t=1,p=256$" + salt + "$" + key},
+		{name: "zero time", encoded: "$argon2id$v=19$m=64,t=0,p=1$" + salt + "$" + key},
+		{name: "huge time", encoded: "$argon2id$v=19$m=64,t=4294967295,p=1$" + salt + "$" + key},
+		{name: "memory below threads", encoded: "$argon2id$v=19$m=8,t=1,p=2$" + salt + "$" + key},
+		{name: "huge memory", encoded: "$argon2id$v=19$m=4294967295,t=1,p=1$" + salt + "$" + key},
+		{name: "negative memory", encoded: "$argon2id$v=19$m=-1,t=1,p=1$" + salt + "$" + key},
+		{name: "empty salt", encoded: "$argon2id$v=19$m=64,t=1,p=1$$" + key},
+		{name: "short salt", encoded: "$argon2id$v=19$m=64,t=1,p=1$c2FsdA$" + key},
+		{name: "bad salt", encoded: "$argon2id$v=19$m=64,t=1,p=1$!!!$" + key},
+		{name: "empty key", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$"},
+		{name: "bad key", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$!!!"},
+		{name: "huge key", encoded: "$argon2id$v=19$m=64,t=1,p=1$" + salt + "$" + strings.Repeat("a", 2000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, rehash, err := testArgon2id().Verify("correct horse", tt.encoded)
+			if !errors.Is(err, ErrBadHash) || ok || rehash {
+				t.Errorf("ok %t, rehash %t, error %v, want ErrBadHash", ok, rehash, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,116 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+)
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// onChangePassword keeps the current session and revokes the others,
+// their access tokens live until expiration
+func (s *Server) onChangePassword(c echo.Context) error {
+	claims, err := s.getClaims(c)
+	if err != nil {
+		return err
+	}
+
+	var req changePasswordRequest
+	if err = c.Bind(&req); err != nil {
+		return bindError(err)
+	}
+	if req.NewPassword == "" {
+		return invalidField("new_password", "is required")
+	}
+
+	ctx := c.Request().Context()
+
+	err = s.storage.ChangePassword(ctx, claims.UserID, req.CurrentPassword, req.NewPassword)
+	if err != nil {
+		return accountError(err, "change password")
+	}
+
+	if err = s.storage.RevokeUserSessions(ctx, claims.UserID, claims.SessionID); err != nil {
+		return errors.Wrap(err, "revoke user sessions")
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+func (s *Server) onChangeLogin(c echo.Context) error {
+	userID, err := s.getUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Login string `json:"login"`
+	}
+	if err = c.Bind(&req); err != nil {
+		return bindError(err)
+	}
+	if req.Login == "" {
+		return invalidField("login", "is required")
+	}
+
+	err = s.storage.ChangeLogin(c.Request().Context(), userID, req.Login)
+	if err != nil {
+		if errors.Is(err, entities.ErrConflict) {
+			return newAPIError(http.StatusConflict, CodeLoginTaken, "login already exists")
+		}
+		return accountError(err, "change login")
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// onDeleteUser requires password, what happens with user data depends on
+// Account.DeletePolicy
+func (s *Server) onDeleteUser(c echo.Context) error {
+	claims, err := s.getClaims(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err = c.Bind(&req); err != nil {
+		return bindError(err)
+	}
+
+	ctx := c.Request().Context()
+
+	err = s.storage.DeleteUser(ctx, claims.UserID, req.Password)
+	if err != nil {
+		return accountError(err, "delete user")
+	}
+
+	err = s.storage.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		s.logger.Error(errors.Wrap(err, "revoke access token"))
+	}
+
+	s.clearTokens(c)
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+// accountError maps errors of the current user account, a missing user
+// means the token outlived the account
+func accountError(err error, action string) error {
+	switch {
+	case errors.Is(err, entities.ErrBadLogin):
+		return newAPIError(http.StatusUnauthorized, CodeInvalidCredentials, "wrong password")
+	case errors.Is(err, entities.ErrNotFound):
+		return errUnauthorized
+	}
+
+	return errors.Wrap(err, action)
+}
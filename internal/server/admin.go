@@ -0,0 +1,360 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/worker"
+)
+
+const defaultAuditLimit = 100
+
+// requireRole checks role in database on every request, so revoked roles
+// don't live until token expiration
+func (s *Server) requireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, err := s.getUserID(c)
+			if err != nil {
+				return err
+			}
+
+			current, err := s.storage.UserRole(c.Request().Context(), userID)
+			if err != nil {
+				if errors.Is(err, entities.ErrNotFound) {
+					return errUnauthorized
+				}
+				return errors.Wrap(err, "get user role")
+			}
+
+			if storage.RoleRank(current) < storage.RoleRank(role) {
+				return newAPIError(http.StatusForbidden, CodeForbidden, "forbidden")
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// auditRecord is an action of the current user
+func (s *Server) auditRecord(c echo.Context, action string, targetUserID int, details any) (storage.AuditRecord, error) {
+	actorID, err := s.getUserID(c)
+	if err != nil {
+		return storage.AuditRecord{}, err
+	}
+
+	data, err := json.Marshal(details)
+	if err != nil {
+		return storage.AuditRecord{}, errors.Wrap(err, "marshal audit details")
+	}
+
+	return storage.AuditRecord{
+		ActorID:      actorID,
+		Action:       action,
+		TargetUserID: targetUserID,
+		Details:      data,
+	}, nil
+}
+
+// audit records action of the current user, an admin action without
+// audit record is an error
+func (s *Server) audit(c echo.Context, action string, targetUserID int, details any) error {
+	record, err := s.auditRecord(c, action, targetUserID, details)
+	if err != nil {
+		return err
+	}
+
+	err = s.storage.WriteAudit(c.Request().Context(), record)
+	if err != nil {
+		s.logger.WithFields(logrus.Fields{
+			"actor":  record.ActorID,
+			"action": action,
+			"target": targetUserID,
+		}).Error(errors.Wrap(err, "write audit"))
+		return errors.Wrap(err, "write audit")
+	}
+
+	return nil
+}
+
+func targetUserID(c echo.Context) (int, error) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		return 0, invalidField("id", "must be a number")
+	}
+
+	return id, nil
+}
+
+var errUserNotFound = newAPIError(http.StatusNotFound, CodeNotFound, "user not found")
+
+func (s *Server) onAdminFindUser(c echo.Context) error {
+	login := c.QueryParam("login")
+	if login == "" {
+		return invalidField("login", "is required")
+	}
+
+	user, err := s.storage.UserByLogin(c.Request().Context(), login)
+	if err != nil {
+		if errors.Is(err, entities.ErrNotFound) {
+			return errUserNotFound
+		}
+		return errors.Wrap(err, "get user by login")
+	}
+
+	if err = s.audit(c, "user.lookup", user.ID, map[string]string{"login": login}); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, user)
+}
+
+func (s *Server) onAdminUserOrders(c echo.Context) error {
+	id, err := targetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	q, err := parseListQuery(c, id, true)
+	if err != nil {
+		return err
+	}
+
+	//role lookup is the cheapest user check
+	if _, err = s.storage.UserRole(c.Request().Context(), id); err != nil {
+		return targetUserError(err)
+	}
+
+	page, err := s.storage.ListOrders(c.Request().Context(), q)
+	if err != nil {
+		return errors.Wrap(err, "list orders")
+	}
+
+	if err = s.audit(c, "user.orders", id, nil); err != nil {
+		return err
+	}
+	setNextPage(c, page.NextCursor)
+
+	return c.JSON(http.StatusOK, page.Orders)
+}
+
+func (s *Server) onAdminUserWithdrawals(c echo.Context) error {
+	id, err := targetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	q, err := parseListQuery(c, id, false)
+	if err != nil {
+		return err
+	}
+
+	if _, err = s.storage.UserRole(c.Request().Context(), id); err != nil {
+		return targetUserError(err)
+	}
+
+	page, err := s.storage.ListWithdrawals(c.Request().Context(), q)
+	if err != nil {
+		return errors.Wrap(err, "list withdrawals")
+	}
+
+	if err = s.audit(c, "user.withdrawals", id, nil); err != nil {
+		return err
+	}
+	setNextPage(c, page.NextCursor)
+
+	return c.JSON(http.StatusOK, page.Bills)
+}
+
+func targetUserError(err error) error {
+	if errors.Is(err, entities.ErrBadLogin) || errors.Is(err, entities.ErrNotFound) {
+		return errUserNotFound
+	}
+
+	return errors.Wrap(err, "get user")
+}
+
+type adjustmentRequest struct {
+	Amount entities.Points `json:"amount"`
+	Reason string          `json:"reason"`
+}
+
+func (s *Server) onAdminAdjustBalance(c echo.Context) error {
+	id, err := targetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req adjustmentRequest
+	if err = c.Bind(&req); err != nil {
+		return bindError(err)
+	}
+	if req.Amount == 0 {
+		return invalidField("amount", "must not be zero")
+	}
+	if req.Amount > entities.MaxPoints || req.Amount < -entities.MaxPoints {
+		return unprocessableField("amount", "must not exceed "+entities.MaxPoints.String()+" by absolute value")
+	}
+
+	//reason is mandatory
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.Reason == "" {
+		return invalidField("reason", "is required")
+	}
+
+	record, err := s.auditRecord(c, "balance.adjust", id, req)
+	if err != nil {
+		return err
+	}
+
+	//audit record is written in the same transaction
+	err = s.storage.AdjustBalance(c.Request().Context(), id, req.Amount, req.Reason, record)
+	if err != nil {
+		if errors.Is(err, entities.ErrHaveEnoughMoney) {
+			return newAPIError(http.StatusConflict, CodeInsufficientFunds, "balance can't go below zero")
+		}
+		if errors.Is(err, entities.ErrNotFound) {
+			return errUserNotFound
+		}
+		return errors.Wrap(err, "adjust balance")
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+func (s *Server) onAdminSetRole(c echo.Context) error {
+	id, err := targetUserID(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Role string `json:"role"`
+	}
+	if err = c.Bind(&req); err != nil {
+		return bindError(err)
+	}
+	if storage.RoleRank(req.Role) == 0 {
+		return invalidField("role", "unknown role")
+	}
+
+	err = s.storage.SetUserRole(c.Request().Context(), id, req.Role)
+	if err != nil {
+		if errors.Is(err, entities.ErrNotFound) {
+			return errUserNotFound
+		}
+		return errors.Wrap(err, "set user role")
+	}
+
+	if err = s.audit(c, "user.role", id, req); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+func (s *Server) onAdminRecheckOrder(c echo.Context) error {
+	number := c.Param("number")
+
+	current, err := s.storage.Order(c.Request().Context(), number)
+	if err != nil {
+		if errors.Is(err, entities.ErrNotFound) {
+			return newAPIError(http.StatusNotFound, CodeNotFound, "order not found")
+		}
+		return errors.Wrap(err, "get order")
+	}
+
+	//recheck may change the order before it fails, so it's audited up front
+	err = s.audit(c, "order.recheck", current.UserID, map[string]string{
+		"order":  current.Number,
+		"status": current.Status,
+	})
+	if err != nil {
+		return err
+	}
+
+	log := s.logger.WithFields(logrus.Fields{
+		"order":  number,
+		"status": current.Status,
+	})
+
+	order, err := s.poller.Recheck(c.Request().Context(), number)
+	if err != nil {
+		log.Warn(errors.Wrap(err, "recheck order"))
+
+		switch {
+		case errors.Is(err, entities.ErrNotFound):
+			return newAPIError(http.StatusNotFound, CodeNotFound, "order not found")
+		case errors.Is(err, entities.ErrConflict):
+			return newAPIError(http.StatusConflict, CodeConflict, "order is already "+order.Status)
+		}
+
+		//storage failures are ours
+		var accrualErr *worker.AccrualError
+		if errors.As(err, &accrualErr) {
+			return newAPIError(http.StatusBadGateway, CodeBadGateway, "accrual system request failed").wrap(err)
+		}
+		return errors.Wrap(err, "recheck order")
+	}
+
+	log.Infof("order rechecked, status %s", order.Status)
+
+	return c.JSON(http.StatusOK, order)
+}
+
+func (s *Server) onUnlockUser(c echo.Context) error {
+	var req struct {
+		Login string `json:"login"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return bindError(err)
+	}
+	if req.Login == "" {
+		return invalidField("login", "is required")
+	}
+
+	if err := s.throttle.Unlock(c.Request().Context(), req.Login); err != nil {
+		return errors.Wrap(err, "unlock login")
+	}
+
+	if err := s.audit(c, "user.unlock", 0, req); err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+func (s *Server) onAdminAuditLog(c echo.Context) error {
+	var userID int
+	if param := c.QueryParam("user_id"); param != "" {
+		id, err := strconv.Atoi(param)
+		if err != nil {
+			return invalidField("user_id", "must be a number")
+		}
+		userID = id
+	}
+
+	limit := defaultAuditLimit
+	if param := c.QueryParam("limit"); param != "" {
+		n, err := strconv.Atoi(param)
+		if err != nil || n <= 0 {
+			return invalidField("limit", "must be a positive number")
+		}
+		limit = min(n, 1000)
+	}
+
+	records, err := s.storage.AuditLog(c.Request().Context(), userID, limit)
+	if err != nil {
+		return errors.Wrap(err, "get audit log")
+	}
+
+	return c.JSON(http.StatusOK, records)
+}
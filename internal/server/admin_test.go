@@ -0,0 +1,224 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/storage/storagetest"
+)
+
+func TestAdminAdjustBalance(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	_, auth := s.register(t, "admin", storage.RoleAdmin)
+	alice, _ := s.register(t, "alice", storage.RoleUser)
+
+	target := fmt.Sprintf("/api/admin/users/%d/adjustments", alice.ID)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "no reason", body: `{"amount":5}`, status: http.StatusBadRequest},
+		{name: "blank reason", body: `{"amount":5,"reason":" \t "}`, status: http.StatusBadRequest},
+		{name: "zero amount", body: `{"amount":0,"reason":"bonus"}`, status: http.StatusBadRequest},
+		{name: "out of range", body: `{"amount":1000000000000,"reason":"bonus"}`, status: http.StatusUnprocessableEntity},
+		{name: "below zero", body: `{"amount":-5,"reason":"penalty"}`, status: http.StatusConflict},
+		{name: "credit", body: `{"amount":5,"reason":" bonus "}`, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := jsonRequest(http.MethodPost, target, tt.body)
+			req.Header.Set("Authorization", auth)
+
+			if rec := s.serve(req); rec.Code != tt.status {
+				t.Fatalf("status is %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+		})
+	}
+
+	//only the applied adjustment is audited
+	records, err := s.storage.AuditLog(context.Background(), alice.ID, 10)
+	if err != nil {
+		t.Fatalf("audit log: %v", err)
+	}
+	if len(records) != 1 || records[0].Action != "balance.adjust" {
+		t.Fatalf("audit log is %+v, want one balance.adjust", records)
+	}
+	if got := string(records[0].Details); got != `{"amount":5,"reason":"bonus"}` {
+		t.Errorf("audit details are %s", got)
+	}
+}
+
+// failingUpdates is memory storage that can't store accrual results
+type failingUpdates struct {
+	*storage.MemoryStorage
+}
+
+func (failingUpdates) UpdateOrder(context.Context, storage.Order) error {
+	return errors.New("connection refused")
+}
+
+// failingAudit is memory storage that can't write audit log
+type failingAudit struct {
+	*storage.MemoryStorage
+}
+
+func (failingAudit) WriteAudit(context.Context, storage.AuditRecord) error {
+	return errors.New("connection refused")
+}
+
+func TestAdminRecheckOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		//accrual system answer
+		status   int
+		body     string
+		storage  func(do.Injector) (storage.DataKeeper, error)
+		want     int
+		wantCode string
+		//order status after the request
+		wantStatus string
+		audited    bool
+	}{
+		{
+			name:   "processed",
+			status: http.StatusOK,
+			body:   `{"status":"PROCESSED","accrual":10}`,
+			want:   http.StatusOK,
+
+			wantStatus: storage.StatusProcessed,
+			audited:    true,
+		},
+		{
+			name:     "accrual system fails",
+			status:   http.StatusInternalServerError,
+			want:     http.StatusBadGateway,
+			wantCode: CodeBadGateway,
+
+			wantStatus: storage.StatusNew,
+			audited:    true,
+		},
+		{
+			name:     "unknown accrual status",
+			status:   http.StatusOK,
+			body:     `{"status":"LOST"}`,
+			want:     http.StatusBadGateway,
+			wantCode: CodeBadGateway,
+
+			wantStatus: storage.StatusNew,
+			audited:    true,
+		},
+		{
+			name:   "storage fails",
+			status: http.StatusOK,
+			body:   `{"status":"PROCESSED","accrual":10}`,
+			storage: func(i do.Injector) (storage.DataKeeper, error) {
+				m, err := storage.NewMemoryStorage(i)
+				return failingUpdates{m}, err
+			},
+			want:     http.StatusInternalServerError,
+			wantCode: CodeInternal,
+
+			wantStatus: storage.StatusNew,
+			audited:    true,
+		},
+		{
+			name:   "audit fails",
+			status: http.StatusOK,
+			body:   `{"status":"PROCESSED","accrual":10}`,
+			storage: func(i do.Injector) (storage.DataKeeper, error) {
+				m, err := storage.NewMemoryStorage(i)
+				return failingAudit{m}, err
+			},
+			want:     http.StatusInternalServerError,
+			wantCode: CodeInternal,
+
+			//order is untouched without audit record
+			wantStatus: storage.StatusNew,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accrualSystem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				_, _ = io.WriteString(w, tt.body)
+			}))
+			defer accrualSystem.Close()
+
+			cfg := testConfig()
+			cfg.AccrualSystem.URL = accrualSystem.URL
+
+			var overrides []func(do.Injector)
+			if tt.storage != nil {
+				overrides = append(overrides, func(i do.Injector) {
+					do.Override(i, tt.storage)
+				})
+			}
+
+			s := newTestServer(t, cfg, overrides...)
+			_, auth := s.register(t, "support", storage.RoleSupport)
+			alice, _ := s.register(t, "alice", storage.RoleUser)
+
+			number := storagetest.LuhnNumber(1001)
+			err := s.storage.CreateOrder(context.Background(), storage.Order{
+				UserID:     alice.ID,
+				Number:     number,
+				Status:     storage.StatusNew,
+				UploadedAt: time.Now().Format(time.RFC3339),
+			})
+			if err != nil {
+				t.Fatalf("create order: %v", err)
+			}
+
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/orders/"+number+"/recheck", nil)
+			req.Header.Set("Authorization", auth)
+
+			rec := s.serve(req)
+			if rec.Code != tt.want {
+				t.Fatalf("status is %d, want %d: %s", rec.Code, tt.want, rec.Body)
+			}
+			if tt.wantCode != "" && !strings.Contains(rec.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("body is %s, want code %s", rec.Body, tt.wantCode)
+			}
+
+			order, err := s.storage.Order(context.Background(), number)
+			if err != nil {
+				t.Fatalf("get order: %v", err)
+			}
+			if order.Status != tt.wantStatus {
+				t.Errorf("order status is %s, want %s", order.Status, tt.wantStatus)
+			}
+
+			//attempt is audited whatever its outcome
+			records, err := s.storage.AuditLog(context.Background(), alice.ID, 10)
+			if err != nil {
+				t.Fatalf("audit log: %v", err)
+			}
+			if !tt.audited {
+				if len(records) != 0 {
+					t.Errorf("audit log is %+v, want none", records)
+				}
+				return
+			}
+			if len(records) != 1 || records[0].Action != "order.recheck" {
+				t.Fatalf("audit log is %+v, want one order.recheck", records)
+			}
+			if got, want := string(records[0].Details), `{"order":"`+number+`","status":"NEW"}`; got != want {
+				t.Errorf("audit details are %s, want %s", got, want)
+			}
+		})
+	}
+}
@@ -1,97 +1,293 @@
 package server
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/labstack/echo/v4"
 	"github.com/pkg/errors"
+	"github.com/wickedv43/yd-diploma/internal/entities"
 	"github.com/wickedv43/yd-diploma/internal/storage"
 )
 
+var errUnauthorized = errors.New("unauthorized")
+
 var (
-	secretKey  = []byte("supersecretkey")
-	cookieName = "auth_token"
+	bearerPrefix      = "Bearer "
+	cookieName        = "auth_token"
+	refreshCookieName = "refresh_token"
+	//refresh token is only sent to session endpoints
+	refreshCookiePath = "/api/user"
 )
 
+// tokenResponse lets clients without cookies keep the session
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int    `json:"expires_in"`
+	RefreshToken string `json:"refresh_token"`
+}
+
 type Claims struct {
 	jwt.RegisteredClaims
 	UserID int `json:"login"`
+	// SessionID is the refresh token family the access token was issued for
+	SessionID string `json:"sid"`
 }
 
-func (s *Server) createJWT(u storage.User) (string, error) {
+func randomToken(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "read random")
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashRefreshToken only the hash is stored, so a database leak doesn't leak sessions
+func hashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (s *Server) createJWT(userID int, sessionID string) (string, error) {
+	jti, err := randomToken(16)
+	if err != nil {
+		return "", errors.Wrap(err, "generate jti")
+	}
+
+	now := time.Now()
 	claims := Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(24 * time.Hour)),
+			ID:        jti,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(s.cfg.Auth.AccessTTL)),
 		},
-		UserID: u.ID,
+		UserID:    userID,
+		SessionID: sessionID,
+	}
+
+	return s.keyring.Sign(claims)
+}
+
+// authorize starts a new session for the user
+func (s *Server) authorize(c echo.Context, u storage.User) (tokenResponse, error) {
+	familyID, err := randomToken(16)
+	if err != nil {
+		return tokenResponse{}, errors.Wrap(err, "generate session id")
+	}
+
+	refresh, err := randomToken(32)
+	if err != nil {
+		return tokenResponse{}, errors.Wrap(err, "generate refresh token")
+	}
+
+	token := storage.RefreshToken{
+		Hash:      hashRefreshToken(refresh),
+		FamilyID:  familyID,
+		UserID:    u.ID,
+		ExpiresAt: time.Now().Add(s.cfg.Auth.RefreshTTL),
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	err = s.storage.CreateRefreshToken(c.Request().Context(), token)
+	if err != nil {
+		return tokenResponse{}, errors.Wrap(err, "create refresh token")
+	}
 
-	return token.SignedString(secretKey)
+	return s.setTokens(c, token, refresh)
 }
 
-// TODO: check it
-func (s *Server) authorize(c echo.Context, u storage.User) echo.Context {
-	jwtToken, err := s.createJWT(u)
+// setTokens sends tokens in cookies and Authorization header
+func (s *Server) setTokens(c echo.Context, token storage.RefreshToken, refresh string) (tokenResponse, error) {
+	jwtToken, err := s.createJWT(token.UserID, token.FamilyID)
 	if err != nil {
-		return nil
+		return tokenResponse{}, errors.Wrap(err, "create jwt")
 	}
 
-	cookie := &http.Cookie{
+	c.Response().Header().Set(echo.HeaderAuthorization, bearerPrefix+jwtToken)
+
+	c.SetCookie(&http.Cookie{
 		Name:     cookieName,
 		Value:    jwtToken,
 		Path:     "/",
 		HttpOnly: true,
-		Expires:  time.Now().Add(24 * time.Hour),
+		Expires:  time.Now().Add(s.cfg.Auth.AccessTTL),
+	})
+
+	c.SetCookie(&http.Cookie{
+		Name:     refreshCookieName,
+		Value:    refresh,
+		Path:     refreshCookiePath,
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+		Expires:  token.ExpiresAt,
+	})
+
+	return tokenResponse{
+		AccessToken:  jwtToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(s.cfg.Auth.AccessTTL.Seconds()),
+		RefreshToken: refresh,
+	}, nil
+}
+
+func (s *Server) clearTokens(c echo.Context) {
+	c.SetCookie(&http.Cookie{
+		Name:     cookieName,
+		Path:     "/",
+		HttpOnly: true,
+		MaxAge:   -1,
+	})
+
+	c.SetCookie(&http.Cookie{
+		Name:     refreshCookieName,
+		Path:     refreshCookiePath,
+		HttpOnly: true,
+		MaxAge:   -1,
+	})
+}
+
+// accessToken takes the token from Authorization header, then from cookie
+func accessToken(c echo.Context) (string, error) {
+	header := c.Request().Header.Get(echo.HeaderAuthorization)
+	if header != "" {
+		if len(header) <= len(bearerPrefix) || !strings.EqualFold(header[:len(bearerPrefix)], bearerPrefix) {
+			return "", errUnauthorized
+		}
+		return strings.TrimSpace(header[len(bearerPrefix):]), nil
 	}
 
-	c.SetCookie(cookie)
+	cookie, err := c.Cookie(cookieName)
+	if err != nil || cookie.Value == "" {
+		return "", errUnauthorized
+	}
 
-	return c
+	return cookie.Value, nil
 }
 
-func (s *Server) getUserIDFromCookie(cookie *http.Cookie) (int, error) {
+func (s *Server) parseClaims(tokenString string) (*Claims, error) {
+	token, err := s.keyring.Parse(tokenString, &Claims{})
+	if err != nil {
+		return nil, errors.Wrap(errUnauthorized, err.Error())
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid || claims.ExpiresAt == nil {
+		return nil, errUnauthorized
+	}
+
+	return claims, nil
+}
+
+func (s *Server) getClaims(c echo.Context) (*Claims, error) {
+	claims, ok := c.Get("claims").(*Claims)
+	if !ok {
+		return nil, errors.Wrap(errUnauthorized, "invalid claims")
+	}
+
+	return claims, nil
+}
 
-	token, err := jwt.ParseWithClaims(cookie.Value, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return secretKey, nil
+func (s *Server) onRefreshToken(c echo.Context) error {
+	var req struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	if cookie, err := c.Cookie(refreshCookieName); err == nil {
+		req.RefreshToken = cookie.Value
+	} else if err = c.Bind(&req); err != nil {
+		return bindError(err)
+	}
+
+	if req.RefreshToken == "" {
+		return errUnauthorized
+	}
+
+	refresh, err := randomToken(32)
+	if err != nil {
+		return err
+	}
+
+	token, err := s.storage.RotateRefreshToken(c.Request().Context(), hashRefreshToken(req.RefreshToken), storage.RefreshToken{
+		Hash:      hashRefreshToken(refresh),
+		ExpiresAt: time.Now().Add(s.cfg.Auth.RefreshTTL),
 	})
 	if err != nil {
-		return 0, err
+		if errors.Is(err, entities.ErrNotFound) || errors.Is(err, entities.ErrTokenReused) {
+			s.clearTokens(c)
+			return errors.Wrap(errUnauthorized, err.Error())
+		}
+		return errors.Wrap(err, "rotate refresh token")
 	}
 
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims.UserID, nil
+	resp, err := s.setTokens(c, token, refresh)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) onLogout(c echo.Context) error {
+	claims, err := s.getClaims(c)
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+
+	err = s.storage.RevokeAccessToken(ctx, claims.ID, claims.ExpiresAt.Time)
+	if err != nil {
+		return errors.Wrap(err, "revoke access token")
 	}
 
-	return 0, errors.Wrapf(err, "get login from cookie %s", cookieName)
+	if claims.SessionID != "" {
+		err = s.storage.RevokeRefreshFamily(ctx, claims.SessionID)
+		if err != nil {
+			return errors.Wrap(err, "revoke refresh family")
+		}
+	}
+
+	s.clearTokens(c)
+
+	return c.JSON(http.StatusOK, nil)
+}
+
+func (s *Server) onJWKS(c echo.Context) error {
+	return c.JSON(http.StatusOK, s.keyring.JWKS())
 }
 
 func (s *Server) authMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		//get cookie
-		cookie, err := c.Cookie(cookieName)
+		tokenString, err := accessToken(c)
 		if err != nil {
-			return c.JSON(http.StatusUnauthorized, "unauthorized")
+			return err
 		}
 
-		s.logger.Infof("got cookie %s", cookieName)
-
-		//check cookie exp_at date
-		if cookie.Expires.After(time.Now()) {
-			return c.JSON(http.StatusUnauthorized, "unauthorized")
+		//bad signature, expired, unknown kid
+		claims, err := s.parseClaims(tokenString)
+		if err != nil {
+			return err
 		}
 
-		//get login? or another param
-		userID, err := s.getUserIDFromCookie(cookie)
+		//logged out
+		revoked, err := s.storage.IsAccessTokenRevoked(c.Request().Context(), claims.ID)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, "Getting user from cookie")
+			return errors.Wrap(err, "check access token")
+		}
+		if revoked {
+			return errors.Wrap(errUnauthorized, "token is revoked")
 		}
 
 		//set userID into echo context
-		c.Set("userID", userID)
+		c.Set("userID", claims.UserID)
+		c.Set("claims", claims)
 
 		return next(c)
 	}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	ctx := context.Background()
+
+	user, err := s.storage.RegisterUser(ctx, storage.AuthData{Login: "alice", Password: "password"})
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+
+	now := time.Now()
+	claims := func(jti string, exp *jwt.NumericDate) Claims {
+		return Claims{
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:        jti,
+				IssuedAt:  jwt.NewNumericDate(now),
+				ExpiresAt: exp,
+			},
+			UserID: user.ID,
+		}
+	}
+	//sign bypasses keyring to forge kid, algorithm and key
+	sign := func(method jwt.SigningMethod, kid string, key any, c Claims) string {
+		token := jwt.NewWithClaims(method, c)
+		token.Header["kid"] = kid
+
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return signed
+	}
+	valid := func(jti string) string {
+		token, err := s.keyring.Sign(claims(jti, jwt.NewNumericDate(now.Add(time.Hour))))
+		if err != nil {
+			t.Fatalf("sign: %v", err)
+		}
+		return token
+	}
+
+	revoked := valid("revoked")
+	if err = s.storage.RevokeAccessToken(ctx, "revoked", now.Add(time.Hour)); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	expired, err := s.keyring.Sign(claims("expired", jwt.NewNumericDate(now.Add(-time.Minute))))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+	noExp, err := s.keyring.Sign(claims("no-exp", nil))
+	if err != nil {
+		t.Fatalf("sign: %v", err)
+	}
+
+	exp := jwt.NewNumericDate(now.Add(time.Hour))
+	unknownKid := sign(jwt.SigningMethodHS256, "other", []byte(testSecret), claims("kid", exp))
+	badSignature := sign(jwt.SigningMethodHS256, "default", []byte("another-secret-of-at-least-32-bytes"), claims("sig", exp))
+	algNone := sign(jwt.SigningMethodNone, "default", jwt.UnsafeAllowNoneSignatureType, claims("none", exp))
+
+	tests := []struct {
+		name   string
+		header string
+		cookie string
+		status int
+	}{
+		{name: "bearer header", header: "Bearer " + valid("bearer"), status: http.StatusOK},
+		{name: "bearer scheme in lower case", header: "bearer " + valid("lower"), status: http.StatusOK},
+		{name: "cookie", cookie: valid("cookie"), status: http.StatusOK},
+		{name: "header wins over cookie", header: "Bearer " + valid("header"), cookie: "garbage", status: http.StatusOK},
+		{name: "no credentials", status: http.StatusUnauthorized},
+		{name: "basic scheme", header: "Basic YWxpY2U6cGFzc3dvcmQ=", status: http.StatusUnauthorized},
+		{name: "bearer without token", header: "Bearer ", status: http.StatusUnauthorized},
+		{name: "token without scheme", header: valid("raw"), status: http.StatusUnauthorized},
+		{name: "malformed token", header: "Bearer not.a.jwt", status: http.StatusUnauthorized},
+		{name: "malformed cookie", cookie: "not.a.jwt", status: http.StatusUnauthorized},
+		{name: "expired token", header: "Bearer " + expired, status: http.StatusUnauthorized},
+		{name: "unknown kid", header: "Bearer " + unknownKid, status: http.StatusUnauthorized},
+		{name: "bad signature", header: "Bearer " + badSignature, status: http.StatusUnauthorized},
+		{name: "alg none", header: "Bearer " + algNone, status: http.StatusUnauthorized},
+		{name: "missing exp", header: "Bearer " + noExp, status: http.StatusUnauthorized},
+		{name: "revoked jti", header: "Bearer " + revoked, status: http.StatusUnauthorized},
+		{name: "revoked jti in cookie", cookie: revoked, status: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/user/balance", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: cookieName, Value: tt.cookie})
+			}
+
+			rec := s.serve(req)
+
+			if rec.Code != tt.status {
+				t.Fatalf("status is %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+			if tt.status != http.StatusUnauthorized {
+				return
+			}
+
+			//every failure looks the same
+			var resp struct {
+				Error APIError `json:"error"`
+			}
+			if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("decode body %q: %v", rec.Body, err)
+			}
+			if resp.Error.Code != CodeUnauthorized || resp.Error.Message != "unauthorized" {
+				t.Errorf("error is %+v, want %s", resp.Error, CodeUnauthorized)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+)
+
+// error codes, clients should rely on them and not on messages
+const (
+	CodeBadRequest         = "bad_request"
+	CodeValidation         = "validation_failed"
+	CodeUnauthorized       = "unauthorized"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeForbidden          = "forbidden"
+	CodeNotFound           = "not_found"
+	CodeMethodNotAllowed   = "method_not_allowed"
+	CodeConflict           = "conflict"
+	CodeLoginTaken         = "login_taken"
+	CodeOrderTaken         = "order_taken"
+	CodeInvalidOrder       = "invalid_order_number"
+	CodeInsufficientFunds  = "insufficient_funds"
+	CodeTooManyRequests    = "too_many_requests"
+	//idempotency key of another request or of one in flight
+	CodeIdempotencyKeyReused  = "idempotency_key_reused"
+	CodeIdempotencyInProgress = "idempotency_key_in_progress"
+	CodeBadGateway            = "bad_gateway"
+	CodeInternal              = "internal_error"
+)
+
+// APIError is the body of every error response
+type APIError struct {
+	Status    int          `json:"-"`
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id,omitempty"`
+	Details   []FieldError `json:"details,omitempty"`
+
+	// cause is logged and never sent to client
+	cause error
+}
+
+// FieldError is a bad request parameter or body field
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+type errorResponse struct {
+	Error *APIError `json:"error"`
+}
+
+func newAPIError(status int, code, message string) *APIError {
+	return &APIError{
+		Status:  status,
+		Code:    code,
+		Message: message,
+	}
+}
+
+func (e *APIError) Error() string {
+	if e.cause != nil {
+		return e.Message + ": " + e.cause.Error()
+	}
+	return e.Message
+}
+
+func (e *APIError) Unwrap() error {
+	return e.cause
+}
+
+// wrap keeps err for the log
+func (e *APIError) wrap(err error) *APIError {
+	e.cause = err
+	return e
+}
+
+func badRequest(message string) *APIError {
+	return newAPIError(http.StatusBadRequest, CodeBadRequest, message)
+}
+
+// invalidField is a validation error of one field
+func invalidField(field, message string) *APIError {
+	e := newAPIError(http.StatusBadRequest, CodeValidation, "request validation failed")
+	e.Details = []FieldError{{Field: field, Message: message}}
+
+	return e
+}
+
+// unprocessableField is a valid field the service can't handle, like an
+// amount out of storage range
+func unprocessableField(field, message string) *APIError {
+	e := invalidField(field, message)
+	e.Status = http.StatusUnprocessableEntity
+
+	return e
+}
+
+// bindError is returned when request body can't be parsed
+func bindError(err error) *APIError {
+	return badRequest("invalid request body").wrap(err)
+}
+
+// entityErrors maps storage errors to responses, handlers return their own
+// APIError where a status or message differs
+var entityErrors = []struct {
+	err     error
+	status  int
+	code    string
+	message string
+}{
+	{errUnauthorized, http.StatusUnauthorized, CodeUnauthorized, "unauthorized"},
+	{entities.ErrTokenReused, http.StatusUnauthorized, CodeUnauthorized, "unauthorized"},
+	{entities.ErrBadLogin, http.StatusUnauthorized, CodeInvalidCredentials, "invalid login or password"},
+	{entities.ErrNotFound, http.StatusNotFound, CodeNotFound, "not found"},
+	{entities.ErrConflict, http.StatusConflict, CodeConflict, "conflict"},
+	{entities.ErrAlreadyExists, http.StatusConflict, CodeConflict, "already exists"},
+	{entities.ErrBadOrder, http.StatusUnprocessableEntity, CodeInvalidOrder, "invalid order number"},
+	{entities.ErrHaveEnoughMoney, http.StatusPaymentRequired, CodeInsufficientFunds, "not enough points"},
+	{storage.ErrBadCursor, http.StatusBadRequest, CodeValidation, "bad cursor"},
+}
+
+// httpErrorCodes are codes of echo errors like unknown route
+var httpErrorCodes = map[int]string{
+	http.StatusBadRequest:       CodeBadRequest,
+	http.StatusUnauthorized:     CodeUnauthorized,
+	http.StatusForbidden:        CodeForbidden,
+	http.StatusNotFound:         CodeNotFound,
+	http.StatusMethodNotAllowed: CodeMethodNotAllowed,
+	http.StatusTooManyRequests:  CodeTooManyRequests,
+}
+
+func toAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	for _, e := range entityErrors {
+		if errors.Is(err, e.err) {
+			return newAPIError(e.status, e.code, e.message).wrap(err)
+		}
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Code < http.StatusInternalServerError {
+		code, ok := httpErrorCodes[httpErr.Code]
+		if !ok {
+			code = CodeBadRequest
+		}
+
+		return newAPIError(httpErr.Code, code, http.StatusText(httpErr.Code)).wrap(err)
+	}
+
+	return newAPIError(http.StatusInternalServerError, CodeInternal, "internal server error").wrap(err)
+}
+
+// errorHandler is echo.HTTPErrorHandler, it writes the error envelope
+func (s *Server) errorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	apiErr := *toAPIError(err)
+	apiErr.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+
+	log := s.logger.WithFields(logrus.Fields{
+		"request_id": apiErr.RequestID,
+		"code":       apiErr.Code,
+	})
+	if apiErr.Status >= http.StatusInternalServerError {
+		log.Error(err)
+	} else {
+		log.Debug(err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(apiErr.Status)
+	} else {
+		err = c.JSON(apiErr.Status, errorResponse{Error: &apiErr})
+	}
+	if err != nil {
+		s.logger.Error(errors.Wrap(err, "write error response"))
+	}
+}
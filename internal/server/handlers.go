@@ -2,7 +2,9 @@ package server
 
 import (
 	"io"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/labstack/echo/v4"
@@ -17,18 +19,25 @@ func (s *Server) getUserID(c echo.Context) (int, error) {
 
 	userID, ok := uid.(int)
 	if !ok {
-		return 0, errors.New("invalid user id")
+		return 0, errors.Wrap(errUnauthorized, "invalid user id")
 	}
 
 	return userID, nil
 }
 
+func tooManyRequests(c echo.Context, wait time.Duration) error {
+	seconds := int(math.Ceil(wait.Seconds()))
+	c.Response().Header().Set("Retry-After", strconv.Itoa(seconds))
+
+	return newAPIError(http.StatusTooManyRequests, CodeTooManyRequests, "too many login attempts")
+}
+
 func (s *Server) onRegUser(c echo.Context) error {
 	var aud storage.AuthData
 
 	//get log & pass
 	if err := c.Bind(&aud); err != nil {
-		return c.JSON(http.StatusBadRequest, "Bad Request")
+		return bindError(err)
 	}
 
 	//reg user
@@ -36,40 +45,63 @@ func (s *Server) onRegUser(c echo.Context) error {
 	if err != nil {
 		s.logger.Info("reg user: ", aud, err)
 		if errors.Is(err, entities.ErrConflict) {
-			return c.JSON(http.StatusConflict, "login already exists")
+			return newAPIError(http.StatusConflict, CodeLoginTaken, "login already exists")
 		}
-		return c.JSON(http.StatusInternalServerError, err)
+		return errors.Wrap(err, "register user")
 	}
+	s.metrics.registrations.Inc()
 
-	c = s.authorize(c, user)
+	resp, err := s.authorize(c, user)
+	if err != nil {
+		return err
+	}
 
-	return c.JSON(http.StatusOK, nil)
+	return c.JSON(http.StatusOK, resp)
 }
 
 func (s *Server) onLogin(c echo.Context) error {
 	var aud storage.AuthData
 
 	if err := c.Bind(&aud); err != nil {
-		return c.JSON(http.StatusBadRequest, "Bad Request")
+		return bindError(err)
 	}
 
-	user, err := s.storage.LoginUser(c.Request().Context(), aud)
+	ctx := c.Request().Context()
+
+	attempt, err := s.throttle.Reserve(ctx, aud.Login, c.RealIP())
+	if err != nil {
+		return err
+	}
+	if attempt.Wait > 0 {
+		return tooManyRequests(c, attempt.Wait)
+	}
+
+	user, err := s.storage.LoginUser(ctx, aud)
 	if err != nil {
 		if errors.Is(err, entities.ErrBadLogin) {
-			return c.JSON(http.StatusConflict, "permission denied")
+			if lock := s.throttle.Fail(attempt); lock > 0 {
+				return tooManyRequests(c, lock)
+			}
 		}
 
-		return c.JSON(http.StatusInternalServerError, err)
+		return err
 	}
 
-	c = s.authorize(c, user)
+	if err = s.throttle.Succeed(ctx, attempt); err != nil {
+		s.logger.Error(errors.Wrap(err, "reset login attempts"))
+	}
 
-	return c.JSON(http.StatusOK, nil)
+	resp, err := s.authorize(c, user)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, resp)
 }
 
 func (s *Server) onPostOrders(c echo.Context) error {
 	if c.Request().Header.Get("Content-Type") == "application/json" {
-		return c.JSON(http.StatusBadRequest, "Bad request")
+		return badRequest("order number must be sent as text/plain")
 	}
 
 	//get order num
@@ -77,20 +109,20 @@ func (s *Server) onPostOrders(c echo.Context) error {
 
 	orderNum, err := io.ReadAll(body)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "read order number")
+		return badRequest("read order number").wrap(err)
 	}
 
 	//validate orderNum
 	var order storage.Order
 
 	if !util.LuhnCheck(string(orderNum)) {
-		return c.JSON(http.StatusUnprocessableEntity, "Unprocessable Entity")
+		return entities.ErrBadOrder
 	}
 
 	//get userID from cookie
 	userID, err := s.getUserID(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "get user ID")
+		return err
 	}
 
 	order.Number = string(orderNum)
@@ -102,7 +134,7 @@ func (s *Server) onPostOrders(c echo.Context) error {
 	if err != nil {
 		//if another user have same order num
 		if errors.Is(err, entities.ErrConflict) {
-			return c.JSON(http.StatusConflict, "order already loaded by another user")
+			return newAPIError(http.StatusConflict, CodeOrderTaken, "order already loaded by another user")
 		}
 
 		//if user already have this order num
@@ -110,7 +142,7 @@ func (s *Server) onPostOrders(c echo.Context) error {
 			return c.JSON(http.StatusOK, "order already exists")
 		}
 		//another problem
-		return c.JSON(http.StatusInternalServerError, err.Error())
+		return errors.Wrap(err, "create order")
 	}
 
 	return c.JSON(http.StatusAccepted, nil)
@@ -120,36 +152,45 @@ func (s *Server) onGetOrders(c echo.Context) error {
 	//get userID from cookie
 	userID, err := s.getUserID(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "Server error")
+		return err
+	}
+
+	q, err := parseListQuery(c, userID, true)
+	if err != nil {
+		return err
 	}
 
-	//get user from postgres
-	user, err := s.storage.UserData(c.Request().Context(), userID)
+	page, err := s.storage.ListOrders(c.Request().Context(), q)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "Server error")
+		return errors.Wrap(err, "list orders")
 	}
 	//if user haven't orders
-	if len(user.Orders) == 0 {
+	if len(page.Orders) == 0 {
 		return c.JSON(http.StatusNoContent, "No content")
 	}
 
-	return c.JSON(http.StatusOK, user.Orders)
+	setNextPage(c, page.NextCursor)
+
+	return c.JSON(http.StatusOK, page.Orders)
 }
 
 func (s *Server) onGetUserBalance(c echo.Context) error {
 	//get userID from cookie
 	userID, err := s.getUserID(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "Server error")
+		return err
 	}
 
-	//get user from postgres
-	user, err := s.storage.UserData(c.Request().Context(), userID)
+	balance, err := s.storage.Balance(c.Request().Context(), userID)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "Server error")
+		//user is deleted, token is still alive
+		if errors.Is(err, entities.ErrNotFound) {
+			return errUnauthorized
+		}
+		return errors.Wrap(err, "get balance")
 	}
 
-	return c.JSON(http.StatusOK, user.Balance)
+	return c.JSON(http.StatusOK, balance)
 }
 
 func (s *Server) onProcessPayment(c echo.Context) error {
@@ -157,23 +198,29 @@ func (s *Server) onProcessPayment(c echo.Context) error {
 
 	//parse req
 	if err := c.Bind(&pr); err != nil {
-		return c.JSON(http.StatusBadRequest, err.Error())
+		return bindError(err)
 	}
 
-	//process payment
-	err := s.storage.ProcessPayment(c.Request().Context(), pr)
+	if pr.Sum <= 0 {
+		return invalidField("sum", "must be positive")
+	}
+	if pr.Sum > entities.MaxPoints {
+		return unprocessableField("sum", "must not exceed "+entities.MaxPoints.String())
+	}
+
+	userID, err := s.getUserID(c)
 	if err != nil {
-		//if bad order num
-		if errors.Is(err, entities.ErrBadOrder) {
-			return c.JSON(http.StatusUnprocessableEntity, err.Error())
-		}
-		//if user have not money
-		if errors.Is(err, entities.ErrHaveEnoughMoney) {
-			return c.JSON(http.StatusPaymentRequired, err.Error())
-		}
+		return err
+	}
 
-		return c.JSON(http.StatusInternalServerError, err.Error())
+	pr.UserID = userID
+
+	//process payment, bad order num is 422 and not enough points is 402
+	err = s.storage.ProcessPayment(c.Request().Context(), pr)
+	if err != nil {
+		return errors.Wrap(err, "process payment")
 	}
+	s.metrics.withdrawn.Add(pr.Sum.Float())
 
 	return c.JSON(http.StatusOK, nil)
 }
@@ -181,14 +228,20 @@ func (s *Server) onProcessPayment(c echo.Context) error {
 func (s *Server) GetUserBills(c echo.Context) error {
 	userID, err := s.getUserID(c)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "Server error")
+		return err
 	}
 
-	//get user
-	user, err := s.storage.UserData(c.Request().Context(), userID)
+	q, err := parseListQuery(c, userID, false)
 	if err != nil {
-		return c.JSON(http.StatusInternalServerError, "Server error")
+		return err
 	}
 
-	return c.JSON(http.StatusOK, user.Bills)
+	page, err := s.storage.ListWithdrawals(c.Request().Context(), q)
+	if err != nil {
+		return errors.Wrap(err, "list withdrawals")
+	}
+
+	setNextPage(c, page.NextCursor)
+
+	return c.JSON(http.StatusOK, page.Bills)
 }
@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/storage/storagetest"
+)
+
+func TestLoginThrottleClientIP(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies string
+		//X-Forwarded-For of the attempt n
+		forwardedFor func(n int) string
+		locked       bool
+	}{
+		{
+			name:         "spoofed header is ignored",
+			forwardedFor: func(n int) string { return fmt.Sprintf("203.0.113.%d", n) },
+			locked:       true,
+		},
+		{
+			name:           "trusted proxy passes client address",
+			trustedProxies: "192.0.2.0/24",
+			forwardedFor:   func(n int) string { return fmt.Sprintf("203.0.113.%d", n) },
+			locked:         false,
+		},
+		{
+			name:           "client can't prepend addresses behind trusted proxy",
+			trustedProxies: "192.0.2.0/24",
+			forwardedFor:   func(n int) string { return fmt.Sprintf("203.0.113.%d, 198.51.100.1", n) },
+			locked:         true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := testConfig()
+			cfg.Server.TrustedProxies = tt.trustedProxies
+			//only per-IP lockout can trigger
+			cfg.Throttle.LoginAttempts = 100
+			cfg.Throttle.IPAttempts = 2
+
+			s := newTestServer(t, cfg)
+
+			locked := false
+			for n := 0; n < 5; n++ {
+				body := fmt.Sprintf(`{"login":"user%d","password":"wrong"}`, n)
+				req := jsonRequest(http.MethodPost, "/api/user/login", body)
+				req.RemoteAddr = "192.0.2.10:4000"
+				req.Header.Set("X-Forwarded-For", tt.forwardedFor(n))
+				req.Header.Set("X-Real-IP", tt.forwardedFor(n))
+
+				rec := s.serve(req)
+				if rec.Code == http.StatusTooManyRequests {
+					locked = true
+					break
+				}
+				if rec.Code != http.StatusUnauthorized {
+					t.Fatalf("attempt %d: status is %d: %s", n, rec.Code, rec.Body)
+				}
+			}
+
+			if locked != tt.locked {
+				t.Errorf("locked is %t, want %t", locked, tt.locked)
+			}
+		})
+	}
+}
+
+// TestConcurrentWithdrawals spends the balance with parallel requests, postgres
+// runs when STORAGETEST_DSN is set
+func TestConcurrentWithdrawals(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage storagetest.Factory
+	}{
+		{name: "memory", storage: storagetest.Memory},
+		{name: "postgres", storage: storagetest.Postgres},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keeper := tt.storage(t)
+
+			s := newTestServer(t, testConfig(), func(i do.Injector) {
+				do.OverrideValue(i, keeper)
+			})
+			alice, auth := s.register(t, "alice", storage.RoleUser)
+
+			ctx := context.Background()
+			err := s.storage.AdjustBalance(ctx, alice.ID, entities.PointsFromInt(100), "bonus", storage.AuditRecord{
+				Action:       "balance.adjust",
+				TargetUserID: alice.ID,
+			})
+			if err != nil {
+				t.Fatalf("adjust balance: %v", err)
+			}
+
+			const attempts = 25
+
+			var (
+				wg       sync.WaitGroup
+				mu       sync.Mutex
+				statuses = make(map[int]int)
+			)
+
+			for n := 0; n < attempts; n++ {
+				wg.Add(1)
+				go func(n int) {
+					defer wg.Done()
+
+					body := fmt.Sprintf(`{"order":"%s","sum":10}`, storagetest.LuhnNumber(5000+n))
+					req := jsonRequest(http.MethodPost, "/api/user/balance/withdraw", body)
+					req.Header.Set("Authorization", auth)
+
+					rec := s.serve(req)
+
+					mu.Lock()
+					statuses[rec.Code]++
+					mu.Unlock()
+				}(n)
+			}
+			wg.Wait()
+
+			//no double-spend: exactly the balance is withdrawn
+			if statuses[http.StatusOK] != 10 || statuses[http.StatusPaymentRequired] != attempts-10 {
+				t.Errorf("statuses are %v, want 10 of 200 and %d of 402", statuses, attempts-10)
+			}
+
+			balance, err := s.storage.Balance(ctx, alice.ID)
+			if err != nil {
+				t.Fatalf("balance: %v", err)
+			}
+			if balance.Current != 0 || balance.Withdrawn != entities.PointsFromInt(100) {
+				t.Errorf("balance is %+v, want current 0 and withdrawn 100", balance)
+			}
+
+			mismatches, err := s.storage.CheckLedger(ctx)
+			if err != nil {
+				t.Fatalf("check ledger: %v", err)
+			}
+			if len(mismatches) != 0 {
+				t.Errorf("ledger mismatches: %+v", mismatches)
+			}
+		})
+	}
+}
+
+func TestListOrdersPages(t *testing.T) {
+	s := newTestServer(t, testConfig())
+	alice, auth := s.register(t, "alice", storage.RoleUser)
+
+	//more than a default page
+	const orders = storage.DefaultPageSize + 10
+	uploaded := time.Now().Add(-time.Hour)
+	for n := 0; n < orders; n++ {
+		err := s.storage.CreateOrder(context.Background(), storage.Order{
+			UserID:     alice.ID,
+			Number:     storagetest.LuhnNumber(6000 + n),
+			Status:     storage.StatusNew,
+			UploadedAt: uploaded.Add(time.Duration(n) * time.Second).Format(time.RFC3339),
+		})
+		if err != nil {
+			t.Fatalf("create order: %v", err)
+		}
+	}
+
+	list := func(query string) ([]storage.Order, string) {
+		t.Helper()
+
+		req := httptest.NewRequest(http.MethodGet, "/api/user/orders"+query, nil)
+		req.Header.Set("Authorization", auth)
+
+		rec := s.serve(req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status is %d: %s", rec.Code, rec.Body)
+		}
+
+		var page []storage.Order
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatalf("decode body: %v", err)
+		}
+
+		return page, rec.Header().Get("X-Next-Cursor")
+	}
+
+	//clients of the spec get every order
+	if page, next := list(""); len(page) != orders || next != "" {
+		t.Errorf("got %d orders and cursor %q, want %d and none", len(page), next, orders)
+	}
+
+	page, next := list("?limit=" + strconv.Itoa(orders-5))
+	if len(page) != orders-5 || next == "" {
+		t.Fatalf("first page has %d orders and cursor %q", len(page), next)
+	}
+
+	//cursor without limit goes on by default pages
+	if page, next = list("?cursor=" + next); len(page) != 5 || next != "" {
+		t.Errorf("last page has %d orders and cursor %q, want 5 and none", len(page), next)
+	}
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+)
+
+// readinessTimeout limits all health checks of one probe
+const readinessTimeout = 3 * time.Second
+
+const (
+	statusOK          = "ok"
+	statusUnavailable = "unavailable"
+)
+
+// healthResponse has statuses only, errors may hold DSN or hosts and
+// are logged
+type healthResponse struct {
+	Status     string            `json:"status"`
+	Components map[string]string `json:"components,omitempty"`
+}
+
+// onHealthz is liveness, the process answers
+func (s *Server) onHealthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, healthResponse{Status: statusOK})
+}
+
+// onReadyz is readiness, every service built by the injector passes its
+// health check: postgres with migrated schema, accrual client and so on
+func (s *Server) onReadyz(c echo.Context) error {
+	ctx, cancel := context.WithTimeout(c.Request().Context(), readinessTimeout)
+	defer cancel()
+
+	resp := healthResponse{
+		Status:     statusOK,
+		Components: make(map[string]string),
+	}
+
+	for name, err := range s.injector.HealthCheckWithContext(ctx) {
+		name = serviceName(name)
+
+		resp.Components[name] = statusOK
+		if err != nil {
+			s.logger.WithField("check", name).Warn(errors.Wrap(err, "health check"))
+
+			resp.Components[name] = statusUnavailable
+			resp.Status = statusUnavailable
+		}
+	}
+
+	if resp.Status != statusOK {
+		return c.JSON(http.StatusServiceUnavailable, resp)
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// serviceName cuts package path off do service name
+func serviceName(name string) string {
+	pointer := strings.HasPrefix(name, "*")
+
+	name = name[strings.LastIndex(name, "/")+1:]
+	if pointer && !strings.HasPrefix(name, "*") {
+		name = "*" + name
+	}
+
+	return name
+}
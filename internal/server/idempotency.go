@@ -0,0 +1,145 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/pkg/errors"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+)
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotencyKeyMaxLen = 255
+	//response is stored even if client is gone, but not forever
+	idempotencyStoreTimeout = 5 * time.Second
+)
+
+// recorder copies response body while it is written to the client
+type recorder struct {
+	http.ResponseWriter
+	body bytes.Buffer
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+// idempotencyMiddleware replays the stored response for a repeated
+// Idempotency-Key and rejects reuse of a key with another request body
+func (s *Server) idempotencyMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		key := c.Request().Header.Get(idempotencyHeader)
+		if key == "" {
+			return next(c)
+		}
+
+		if len(key) > idempotencyKeyMaxLen {
+			return invalidField(idempotencyHeader, "is too long")
+		}
+
+		userID, err := s.getUserID(c)
+		if err != nil {
+			return err
+		}
+
+		//hash request, body must be restored for handler
+		body, err := io.ReadAll(c.Request().Body)
+		if err != nil {
+			return badRequest("read body").wrap(err)
+		}
+		c.Request().Body = io.NopCloser(bytes.NewReader(body))
+
+		hash := sha256.New()
+		hash.Write([]byte(c.Request().Method + " " + c.Path() + "\n"))
+		hash.Write(body)
+
+		ik := storage.IdempotencyKey{
+			Key:         key,
+			UserID:      userID,
+			RequestHash: hex.EncodeToString(hash.Sum(nil)),
+			ExpiresAt:   time.Now().Add(s.cfg.Idempotency.TTL),
+		}
+
+		stored, err := s.storage.ReserveIdempotencyKey(c.Request().Context(), ik)
+		if err != nil {
+			if !errors.Is(err, entities.ErrAlreadyExists) {
+				return errors.Wrap(err, "reserve idempotency key")
+			}
+
+			if stored.RequestHash != ik.RequestHash {
+				return newAPIError(http.StatusUnprocessableEntity, CodeIdempotencyKeyReused,
+					"idempotency key is used for another request")
+			}
+
+			if stored.StatusCode == 0 {
+				return newAPIError(http.StatusConflict, CodeIdempotencyInProgress,
+					"request with this idempotency key is in progress")
+			}
+
+			//replay
+			c.Response().Header().Set("Idempotent-Replayed", "true")
+			return c.Blob(stored.StatusCode, stored.ContentType, stored.Response)
+		}
+
+		//key is released unless response is stored: on 5xx, on panic of
+		//handler and when saving fails, so the request can be retried
+		saved := false
+		defer func() {
+			if saved {
+				return
+			}
+
+			ctx, cancel := idempotencyContext(c)
+			defer cancel()
+
+			if dErr := s.storage.DeleteIdempotencyKey(ctx, key, userID); dErr != nil {
+				s.logger.Error(errors.Wrap(dErr, "delete idempotency key"))
+			}
+		}()
+
+		rec := &recorder{ResponseWriter: c.Response().Writer}
+		c.Response().Writer = rec
+
+		//error response is written here to be recorded like any other
+		if err = next(c); err != nil {
+			c.Error(err)
+		}
+
+		c.Response().Writer = rec.ResponseWriter
+
+		//failed requests may be retried with the same key
+		if c.Response().Status >= http.StatusInternalServerError {
+			return nil
+		}
+
+		ik.StatusCode = c.Response().Status
+		ik.ContentType = c.Response().Header().Get(echo.HeaderContentType)
+		ik.Response = rec.body.Bytes()
+
+		ctx, cancel := idempotencyContext(c)
+		defer cancel()
+
+		if err = s.storage.SaveIdempotencyResponse(ctx, ik); err != nil {
+			s.logger.Error(errors.Wrap(err, "save idempotency response"))
+			return nil
+		}
+		saved = true
+
+		return nil
+	}
+}
+
+// idempotencyContext outlives the request: the withdrawal may be committed
+// after client has disconnected and its key must not stay in progress
+func idempotencyContext(c echo.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(c.Request().Context()), idempotencyStoreTimeout)
+}
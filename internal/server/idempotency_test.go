@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/storage/storagetest"
+)
+
+// contextStorage is memory storage failing idempotency writes on a done
+// context like postgres does, and panicking on the payments it is told to
+type contextStorage struct {
+	*storage.MemoryStorage
+	panics *atomic.Int32
+}
+
+func (m contextStorage) ProcessPayment(ctx context.Context, bill storage.Bill) error {
+	if m.panics.Add(-1) >= 0 {
+		panic("payment handler panic")
+	}
+
+	return m.MemoryStorage.ProcessPayment(ctx, bill)
+}
+
+func (m contextStorage) SaveIdempotencyResponse(ctx context.Context, key storage.IdempotencyKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.MemoryStorage.SaveIdempotencyResponse(ctx, key)
+}
+
+func (m contextStorage) DeleteIdempotencyKey(ctx context.Context, key string, userID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return m.MemoryStorage.DeleteIdempotencyKey(ctx, key, userID)
+}
+
+func TestIdempotencyRetry(t *testing.T) {
+	tests := []struct {
+		name string
+		//first request
+		cancelled bool
+		panics    int32
+		status    int
+		//retry with the same key
+		retryStatus int
+		replayed    bool
+	}{
+		{
+			name:        "client disconnected",
+			cancelled:   true,
+			status:      http.StatusOK,
+			retryStatus: http.StatusOK,
+			replayed:    true,
+		},
+		{
+			name:        "handler panics",
+			panics:      1,
+			status:      http.StatusInternalServerError,
+			retryStatus: http.StatusOK,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			panics := &atomic.Int32{}
+			panics.Store(tt.panics)
+
+			s := newTestServer(t, testConfig(), func(i do.Injector) {
+				do.Override(i, func(i do.Injector) (storage.DataKeeper, error) {
+					m, err := storage.NewMemoryStorage(i)
+					return contextStorage{MemoryStorage: m, panics: panics}, err
+				})
+			})
+			alice, auth := s.register(t, "alice", storage.RoleUser)
+
+			ctx := context.Background()
+			err := s.storage.AdjustBalance(ctx, alice.ID, entities.Points(10000), "bonus", storage.AuditRecord{
+				Action:       "balance.adjust",
+				TargetUserID: alice.ID,
+			})
+			if err != nil {
+				t.Fatalf("adjust balance: %v", err)
+			}
+
+			body := `{"order":"` + storagetest.LuhnNumber(2001) + `","sum":30}`
+			withdraw := func(ctx context.Context) *http.Request {
+				req := jsonRequest(http.MethodPost, "/api/user/balance/withdraw", body).WithContext(ctx)
+				req.Header.Set("Authorization", auth)
+				req.Header.Set(idempotencyHeader, "retry-key")
+				return req
+			}
+
+			first := ctx
+			if tt.cancelled {
+				var cancel context.CancelFunc
+				first, cancel = context.WithCancel(ctx)
+				cancel()
+			}
+
+			if rec := s.serve(withdraw(first)); rec.Code != tt.status {
+				t.Fatalf("status is %d, want %d: %s", rec.Code, tt.status, rec.Body)
+			}
+
+			rec := s.serve(withdraw(ctx))
+			if rec.Code != tt.retryStatus {
+				t.Fatalf("retry status is %d, want %d: %s", rec.Code, tt.retryStatus, rec.Body)
+			}
+			if replayed := rec.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.replayed {
+				t.Errorf("retry replayed is %t, want %t", replayed, tt.replayed)
+			}
+
+			//withdrawn once
+			balance, err := s.storage.Balance(ctx, alice.ID)
+			if err != nil {
+				t.Fatalf("balance: %v", err)
+			}
+			if balance.Withdrawn != entities.Points(3000) {
+				t.Errorf("withdrawn is %s, want 30", balance.Withdrawn)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+)
+
+var orderStatuses = []string{
+	storage.StatusNew,
+	storage.StatusProcessing,
+	storage.StatusInvalid,
+	storage.StatusProcessed,
+}
+
+// parseListQuery reads limit, cursor, from, to, sort and, for orders, status
+// query params. Statuses are comma separated.
+func parseListQuery(c echo.Context, userID int, withStatus bool) (storage.ListQuery, error) {
+	q := storage.ListQuery{
+		UserID: userID,
+		Cursor: c.QueryParam("cursor"),
+	}
+
+	if limit := c.QueryParam("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return q, invalidField("limit", "must be a positive number")
+		}
+		q.Limit = min(n, storage.MaxPageSize)
+	}
+
+	switch c.QueryParam("sort") {
+	case "", "desc":
+	case "asc":
+		q.Asc = true
+	default:
+		return q, invalidField("sort", "must be asc or desc")
+	}
+
+	var err error
+	if q.From, err = parseTimeParam(c, "from"); err != nil {
+		return q, err
+	}
+	if q.To, err = parseTimeParam(c, "to"); err != nil {
+		return q, err
+	}
+
+	if status := c.QueryParam("status"); withStatus && status != "" {
+		for _, st := range strings.Split(status, ",") {
+			st = strings.ToUpper(strings.TrimSpace(st))
+			if !slices.Contains(orderStatuses, st) {
+				return q, invalidField("status", fmt.Sprintf("unknown status %q", st))
+			}
+			q.Statuses = append(q.Statuses, st)
+		}
+	}
+
+	return q, nil
+}
+
+func parseTimeParam(c echo.Context, name string) (time.Time, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, invalidField(name, "must be RFC3339 time")
+	}
+
+	return t, nil
+}
+
+// setNextPage sets X-Next-Cursor and Link headers when there is a next page
+func setNextPage(c echo.Context, next string) {
+	if next == "" {
+		return
+	}
+
+	u := *c.Request().URL
+	query := u.Query()
+	query.Set("cursor", next)
+	u.RawQuery = query.Encode()
+
+	header := c.Response().Header()
+	header.Set("X-Next-Cursor", next)
+	header.Set("Link", fmt.Sprintf(`<%s>; rel="next"`, u.RequestURI()))
+}
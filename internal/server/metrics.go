@@ -0,0 +1,76 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wickedv43/yd-diploma/internal/metrics"
+)
+
+// unmatchedRoute labels requests to unknown routes, raw paths would blow
+// up label cardinality
+const unmatchedRoute = "unmatched"
+
+type serverMetrics struct {
+	requests      *prometheus.CounterVec
+	duration      *prometheus.HistogramVec
+	registrations prometheus.Counter
+	withdrawn     prometheus.Counter
+}
+
+func newServerMetrics(r *metrics.Registry) *serverMetrics {
+	m := &serverMetrics{
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "http",
+			Name:      "requests_total",
+			Help:      "HTTP requests by method, route and status.",
+		}, []string{"method", "route", "status"}),
+		duration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metrics.Namespace,
+			Subsystem: "http",
+			Name:      "request_duration_seconds",
+			Help:      "HTTP request latency by method, route and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		registrations: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Name:      "registrations_total",
+			Help:      "Registered users.",
+		}),
+		withdrawn: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metrics.Namespace,
+			Name:      "points_withdrawn_total",
+			Help:      "Points spent by users.",
+		}),
+	}
+
+	r.MustRegister(m.requests, m.duration, m.registrations, m.withdrawn)
+
+	return m
+}
+
+// metricsHandler counts requests by route pattern, it wraps logHandler so
+// the status of error responses is already written
+func (s *Server) metricsHandler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		t := time.Now()
+
+		err := next(c)
+
+		route := c.Path()
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		status := strconv.Itoa(c.Response().Status)
+		method := c.Request().Method
+
+		s.metrics.requests.WithLabelValues(method, route, status).Inc()
+		s.metrics.duration.WithLabelValues(method, route, status).Observe(time.Since(t).Seconds())
+
+		return err
+	}
+}
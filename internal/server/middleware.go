@@ -13,10 +13,9 @@ func (s *Server) logHandler(next echo.HandlerFunc) echo.HandlerFunc {
 		t := time.Now()
 		// before requestJSON
 
-		err := next(c)
-
-		if err != nil {
-			return err
+		//error response is written before logging its status
+		if err := next(c); err != nil {
+			c.Error(err)
 		}
 
 		// after requestJSON
@@ -34,6 +33,8 @@ func (s *Server) logHandler(next echo.HandlerFunc) echo.HandlerFunc {
 			"latency":     latency,
 			"resp_size":   respSize,
 			"resp_status": respStatus,
+			"request_id":  c.Response().Header().Get(echo.HeaderXRequestID),
+			"trace_id":    traceID(c),
 		}).Infoln("request")
 
 		return nil
@@ -44,8 +45,9 @@ func (s *Server) CORSMiddleware(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
 		c.Response().Header().Set("Access-Control-Allow-Origin", "*")
 		c.Response().Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Response().Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Response().Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT")
+		c.Response().Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With, Idempotency-Key")
+		c.Response().Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		c.Response().Header().Set("Access-Control-Expose-Headers", "Authorization, Link, X-Next-Cursor, X-Request-Id, Idempotent-Replayed")
 
 		if c.Request().Method == "OPTIONS" {
 			return c.JSON(http.StatusNoContent, "")
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestCORSPreflight(t *testing.T) {
+	s := newTestServer(t, testConfig())
+
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{header: "Access-Control-Allow-Methods", want: http.MethodDelete},
+		{header: "Access-Control-Allow-Headers", want: idempotencyHeader},
+		{header: "Access-Control-Expose-Headers", want: "Idempotent-Replayed"},
+	}
+
+	req := httptest.NewRequest(http.MethodOptions, "/api/user", nil)
+	req.Header.Set("Origin", "https://example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+
+	rec := s.serve(req)
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("status is %d, want %d", rec.Code, http.StatusNoContent)
+	}
+
+	for _, tt := range tests {
+		values := strings.Split(rec.Header().Get(tt.header), ",")
+		for i := range values {
+			values[i] = strings.ToLower(strings.TrimSpace(values[i]))
+		}
+
+		if !slices.Contains(values, strings.ToLower(tt.want)) {
+			t.Errorf("%s is %q, want %s in it", tt.header, rec.Header().Get(tt.header), tt.want)
+		}
+	}
+}
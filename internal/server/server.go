@@ -1,21 +1,42 @@
 package server
 
 import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 	"github.com/pkg/errors"
 	"github.com/samber/do/v2"
 	"github.com/sirupsen/logrus"
 	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/keyring"
 	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/metrics"
 	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/throttle"
+	"github.com/wickedv43/yd-diploma/internal/tracing"
+	"github.com/wickedv43/yd-diploma/internal/worker"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Server struct {
-	echo    *echo.Echo
-	cfg     *config.Config
-	storage storage.DataKeeper
-	logger  *logrus.Entry
+	echo     *echo.Echo
+	cfg      *config.Config
+	storage  storage.DataKeeper
+	logger   *logrus.Entry
+	keyring  *keyring.Keyring
+	throttle *throttle.Throttler
+	poller   *worker.Poller
+	injector do.Injector
+	registry *metrics.Registry
+	metrics  *serverMetrics
+
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
 }
 
 func NewServer(i do.Injector) (*Server, error) {
@@ -29,30 +50,127 @@ func NewServer(i do.Injector) (*Server, error) {
 	s.cfg = do.MustInvoke[*config.Config](i)
 	s.logger = do.MustInvoke[*logger.Logger](i).WithField("component", "server")
 
-	s.storage = do.MustInvoke[*storage.PostgresStorage](i)
+	s.storage = do.MustInvoke[storage.DataKeeper](i)
+	s.keyring = do.MustInvoke[*keyring.Keyring](i)
+	s.throttle = do.MustInvoke[*throttle.Throttler](i)
+	s.poller = do.MustInvoke[*worker.Poller](i)
+	s.injector = i
+	s.registry = do.MustInvoke[*metrics.Registry](i)
+	s.metrics = newServerMetrics(s.registry)
+
+	tp := do.MustInvoke[*tracing.Provider](i)
+	s.tracer = tp.Tracer("github.com/wickedv43/yd-diploma/internal/server")
+	s.propagator = tp.Propagator()
+
+	//errors are written in one envelope
+	s.echo.HTTPErrorHandler = s.errorHandler
+
+	//login throttling is per client IP, headers of clients can't be trusted
+	s.echo.IPExtractor, err = ipExtractor(s.cfg.Server.TrustedProxies)
+	if err != nil {
+		return nil, errors.Wrap(err, "trusted proxies")
+	}
 
 	//middleware
-	s.echo.Use(middleware.Recover(), middleware.Gzip(), s.logHandler, s.CORSMiddleware)
+	s.echo.Use(middleware.RequestID(), middleware.Recover(), middleware.Gzip(), s.metricsHandler, s.traceHandler, s.logHandler, s.CORSMiddleware)
+
+	//probes
+	s.echo.GET(`/healthz`, s.onHealthz)
+	s.echo.GET(`/readyz`, s.onReadyz)
+	s.echo.GET(`/metrics`, echo.WrapHandler(s.registry.Handler()))
 
 	//free routes
 	s.echo.POST(`/api/user/register`, s.onRegUser)
 	s.echo.POST(`/api/user/login`, s.onLogin)
+	s.echo.POST(`/api/user/token/refresh`, s.onRefreshToken)
+	s.echo.GET(`/.well-known/jwks.json`, s.onJWKS)
 
 	//authorized users
 	user := s.echo.Group(``, s.authMiddleware)
 	user.POST(`/api/user/orders`, s.onPostOrders)
 	user.GET(`/api/user/orders`, s.onGetOrders)
 	user.GET(`/api/user/balance`, s.onGetUserBalance)
-	user.POST(`/api/user/balance/withdraw`, s.onProcessPayment)
+	user.POST(`/api/user/balance/withdraw`, s.onProcessPayment, s.idempotencyMiddleware)
 	user.GET(`/api/user/withdrawals`, s.GetUserBills)
+	user.POST(`/api/user/logout`, s.onLogout)
+	user.PUT(`/api/user/password`, s.onChangePassword)
+	user.PUT(`/api/user/login`, s.onChangeLogin)
+	user.DELETE(`/api/user`, s.onDeleteUser)
+
+	//support staff
+	admin := s.echo.Group(`/api/admin`, s.authMiddleware, s.requireRole(storage.RoleSupport))
+	admin.GET(`/users`, s.onAdminFindUser)
+	admin.GET(`/users/:id/orders`, s.onAdminUserOrders)
+	admin.GET(`/users/:id/withdrawals`, s.onAdminUserWithdrawals)
+	admin.POST(`/users/unlock`, s.onUnlockUser)
+	admin.POST(`/orders/:number/recheck`, s.onAdminRecheckOrder)
+
+	//admins only
+	admin.POST(`/users/:id/adjustments`, s.onAdminAdjustBalance, s.requireRole(storage.RoleAdmin))
+	admin.PUT(`/users/:id/role`, s.onAdminSetRole, s.requireRole(storage.RoleAdmin))
+	admin.GET(`/audit`, s.onAdminAuditLog, s.requireRole(storage.RoleAdmin))
 
 	return s, nil
 }
 
-func (s *Server) Start() {
-	s.logger.Info("server started...")
-	err := s.echo.Start(s.cfg.Server.RunAddress)
+// ipExtractor takes the connection address, with trusted proxies the
+// rightmost untrusted X-Forwarded-For address
+func ipExtractor(trustedProxies string) (echo.IPExtractor, error) {
+	if strings.TrimSpace(trustedProxies) == "" {
+		return echo.ExtractIPDirect(), nil
+	}
+
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, cidr := range strings.Split(trustedProxies, ",") {
+		_, ipRange, err := net.ParseCIDR(strings.TrimSpace(cidr))
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %q", cidr)
+		}
+		options = append(options, echo.TrustIPRange(ipRange))
+	}
+
+	return echo.ExtractIPFromXFFHeader(options...), nil
+}
+
+// Start listens synchronously, so a busy port fails the start, and serves
+// in background until Shutdown
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.cfg.Server.RunAddress)
 	if err != nil {
-		s.logger.Fatal(errors.Wrap(err, "start server"))
+		return errors.Wrap(err, "listen")
 	}
+	s.echo.Listener = listener
+
+	go func() {
+		err := s.echo.Start("")
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Error(errors.Wrap(err, "serve"))
+		}
+	}()
+
+	s.logger.Infof("server started on %s", listener.Addr())
+
+	return nil
+}
+
+// Shutdown stops accepting connections and waits for in-flight requests
+// up to Server.ShutdownTimeout, it's called by do before workers and storage
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.cfg.Server.ShutdownTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.Server.ShutdownTimeout)
+		defer cancel()
+	}
+
+	if err := s.echo.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "shutdown server")
+	}
+
+	s.logger.Info("server stopped")
+
+	return nil
 }
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/accrual"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/keyring"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/metrics"
+	"github.com/wickedv43/yd-diploma/internal/password"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/throttle"
+	"github.com/wickedv43/yd-diploma/internal/tracing"
+	"github.com/wickedv43/yd-diploma/internal/worker"
+)
+
+const testSecret = "test-secret-of-at-least-32-bytes!"
+
+func testConfig() *config.Config {
+	cfg := &config.Config{}
+
+	cfg.Auth.PasswordHasher = password.AlgorithmBcrypt
+	cfg.Auth.JWTSecret = testSecret
+	cfg.Auth.AccessTTL = 15 * time.Minute
+	cfg.Auth.RefreshTTL = time.Hour
+	cfg.Idempotency.TTL = time.Hour
+	cfg.Throttle.Store = throttle.StoreMemory
+	cfg.Throttle.LoginAttempts = 5
+	cfg.Throttle.IPAttempts = 20
+	cfg.Throttle.BaseDelay = time.Second
+	cfg.Throttle.MaxLockout = time.Minute
+	cfg.Throttle.Window = time.Minute
+	cfg.AccrualSystem.PollInterval = time.Second
+	cfg.AccrualSystem.Workers = 1
+
+	return cfg
+}
+
+// newTestServer builds server on memory storage, it isn't started and
+// requests go straight to echo. Overrides replace services before build.
+func newTestServer(t *testing.T, cfg *config.Config, overrides ...func(do.Injector)) *Server {
+	t.Helper()
+
+	i := do.New()
+	t.Cleanup(func() {
+		_ = i.Shutdown()
+	})
+
+	do.ProvideValue(i, cfg)
+	do.Provide(i, func(do.Injector) (*logger.Logger, error) {
+		l, err := logger.NewLogger(nil)
+		if err != nil {
+			return nil, err
+		}
+		l.SetOutput(io.Discard)
+		return l, nil
+	})
+	do.Provide(i, metrics.NewRegistry)
+	do.Provide(i, tracing.NewProvider)
+	do.Provide(i, keyring.NewKeyring)
+	do.Provide(i, password.NewManager)
+	do.Provide(i, storage.NewDataKeeper)
+	do.Provide(i, throttle.NewThrottler)
+	do.Provide(i, accrual.NewHTTPClient)
+	do.Provide(i, worker.NewPoller)
+	do.Provide(i, NewServer)
+
+	for _, override := range overrides {
+		override(i)
+	}
+
+	s, err := do.Invoke[*Server](i)
+	if err != nil {
+		t.Fatalf("new server: %v", err)
+	}
+	//recovered panics are logged by echo
+	s.echo.Logger.SetOutput(io.Discard)
+
+	return s
+}
+
+func (s *Server) serve(req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+
+	return rec
+}
+
+func jsonRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	return req
+}
+
+// register makes a user with role and returns Authorization header for it
+func (s *Server) register(t *testing.T, login, role string) (storage.User, string) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	user, err := s.storage.RegisterUser(ctx, storage.AuthData{Login: login, Password: "password"})
+	if err != nil {
+		t.Fatalf("register %s: %v", login, err)
+	}
+
+	if role != storage.RoleUser {
+		if err = s.storage.SetUserRole(ctx, user.ID, role); err != nil {
+			t.Fatalf("set role of %s: %v", login, err)
+		}
+	}
+
+	token, err := s.createJWT(user.ID, "")
+	if err != nil {
+		t.Fatalf("create jwt: %v", err)
+	}
+
+	return user, bearerPrefix + token
+}
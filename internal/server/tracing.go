@@ -0,0 +1,59 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// traceHandler starts a span of the request, continuing the trace of
+// traceparent header. Handlers pass it on with request context.
+func (s *Server) traceHandler(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		req := c.Request()
+
+		route := c.Path()
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		ctx := s.propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+		ctx, span := s.tracer.Start(ctx, req.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(req.Method),
+				semconv.HTTPRoute(route),
+				semconv.URLPath(req.URL.Path),
+				attribute.String("request_id", c.Response().Header().Get(echo.HeaderXRequestID)),
+			),
+		)
+		defer span.End()
+
+		c.SetRequest(req.WithContext(ctx))
+
+		err := next(c)
+
+		status := c.Response().Status
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+
+		return err
+	}
+}
+
+// traceID is empty when the request isn't sampled
+func traceID(c echo.Context) string {
+	sc := trace.SpanContextFromContext(c.Request().Context())
+	if !sc.IsSampled() {
+		return ""
+	}
+
+	return sc.TraceID().String()
+}
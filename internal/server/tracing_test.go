@@ -0,0 +1,116 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/storage/storagetest"
+	"github.com/wickedv43/yd-diploma/internal/tracing"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTraceRecheck(t *testing.T) {
+	var (
+		mu          sync.Mutex
+		traceparent string
+	)
+	accrualSystem := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		traceparent = r.Header.Get("traceparent")
+		mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"PROCESSED","accrual":10}`))
+	}))
+	defer accrualSystem.Close()
+
+	cfg := testConfig()
+	cfg.AccrualSystem.URL = accrualSystem.URL
+	cfg.Tracing.Exporter = tracing.ExporterMemory
+	cfg.Tracing.SampleRatio = 1
+
+	s := newTestServer(t, cfg)
+	_, auth := s.register(t, "support", storage.RoleSupport)
+	alice, _ := s.register(t, "alice", storage.RoleUser)
+
+	number := storagetest.LuhnNumber(1001)
+	err := s.storage.CreateOrder(context.Background(), storage.Order{
+		UserID:     alice.ID,
+		Number:     number,
+		Status:     storage.StatusNew,
+		UploadedAt: time.Now().Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatalf("create order: %v", err)
+	}
+
+	//request continues the trace of the caller
+	const (
+		traceID  = "4bf92f3577b34da6a3ce929d0e0e4736"
+		callerID = "00f067aa0ba902b7"
+	)
+	req := httptest.NewRequest(http.MethodPost, "/api/admin/orders/"+number+"/recheck", nil)
+	req.Header.Set("Authorization", auth)
+	req.Header.Set("traceparent", "00-"+traceID+"-"+callerID+"-01")
+
+	if rec := s.serve(req); rec.Code != http.StatusOK {
+		t.Fatalf("status is %d: %s", rec.Code, rec.Body)
+	}
+
+	spans := make(map[string]tracetest.SpanStub)
+	for _, span := range do.MustInvoke[*tracing.Provider](s.injector).Spans() {
+		if span.SpanContext.TraceID().String() == traceID {
+			spans[span.Name] = span
+		}
+	}
+
+	//span and its parent
+	tree := []struct {
+		name   string
+		parent string
+	}{
+		{name: "POST /api/admin/orders/:number/recheck"},
+		{name: "MemoryStorage.UserRole", parent: "POST /api/admin/orders/:number/recheck"},
+		{name: "MemoryStorage.Order", parent: "POST /api/admin/orders/:number/recheck"},
+		{name: "Poller.check", parent: "POST /api/admin/orders/:number/recheck"},
+		{name: "accrual.GetOrder", parent: "Poller.check"},
+		{name: "GET /api/orders/{number}", parent: "accrual.GetOrder"},
+		{name: "MemoryStorage.UpdateOrder", parent: "Poller.check"},
+	}
+
+	for _, tt := range tree {
+		span, ok := spans[tt.name]
+		if !ok {
+			t.Errorf("span %s is not in trace %s", tt.name, traceID)
+			continue
+		}
+
+		wantParent := callerID
+		if tt.parent != "" {
+			wantParent = spans[tt.parent].SpanContext.SpanID().String()
+		}
+		if got := span.Parent.SpanID().String(); got != wantParent {
+			t.Errorf("parent of %s is %s, want %s %s", tt.name, got, tt.parent, wantParent)
+		}
+	}
+
+	if kind := spans["GET /api/orders/{number}"].SpanKind; kind != trace.SpanKindClient {
+		t.Errorf("accrual request span kind is %s", kind)
+	}
+
+	//accrual system gets the request span as parent
+	mu.Lock()
+	defer mu.Unlock()
+
+	want := "00-" + traceID + "-" + spans["GET /api/orders/{number}"].SpanContext.SpanID().String() + "-01"
+	if traceparent != want {
+		t.Errorf("traceparent is %q, want %q", traceparent, want)
+	}
+}
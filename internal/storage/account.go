@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage/db"
+)
+
+// account deletion policies
+const (
+	// DeletePolicyAnonymize keeps orders, withdrawals and ledger for accounting,
+	// login is freed, remaining balance is written off by an adjustment
+	DeletePolicyAnonymize = "anonymize"
+	// DeletePolicyCascade removes the user with all orders, withdrawals and
+	// ledger transactions
+	DeletePolicyCascade = "cascade"
+)
+
+var ErrUnknownDeletePolicy = errors.New("unknown account delete policy")
+
+// anonymousLogin frees the login of a deleted user, random part keeps it
+// from colliding with a login somebody registered
+func anonymousLogin(userID int32) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", errors.Wrap(err, "read random")
+	}
+
+	return fmt.Sprintf("deleted-%d-%s", userID, hex.EncodeToString(b)), nil
+}
+
+// verifyPassword returns entities.ErrBadLogin if password doesn't match
+func (s *PostgresStorage) verifyPassword(ctx context.Context, q *db.Queries, userID int, pass string) error {
+	user, err := q.GetUserByID(ctx, int32(userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.ErrNotFound
+		}
+		return errors.Wrap(err, "get user by id")
+	}
+
+	ok, _, err := s.hasher.Verify(pass, user.Password)
+	if err != nil {
+		return errors.Wrap(err, "verify password")
+	}
+	if !ok {
+		return entities.ErrBadLogin
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) ChangePassword(ctx context.Context, userID int, current, next string) error {
+	ctx, span := s.startSpan(ctx, "ChangePassword")
+	defer span.End()
+
+	if err := s.verifyPassword(ctx, s.Queries, userID, current); err != nil {
+		return err
+	}
+
+	hash, err := s.hasher.Hash(next)
+	if err != nil {
+		return errors.Wrap(err, "hash password")
+	}
+
+	err = s.Queries.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{
+		ID:       int32(userID),
+		Password: hash,
+	})
+	if err != nil {
+		return errors.Wrap(err, "update user password")
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) ChangeLogin(ctx context.Context, userID int, login string) error {
+	ctx, span := s.startSpan(ctx, "ChangeLogin")
+	defer span.End()
+
+	updated, err := s.Queries.ChangeUserLogin(ctx, db.ChangeUserLoginParams{
+		ID:    int32(userID),
+		Login: login,
+	})
+	if err != nil {
+		if isUniqueViolation(err) {
+			return entities.ErrConflict
+		}
+		return errors.Wrap(err, "change user login")
+	}
+
+	if updated == 0 {
+		return entities.ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteUser removes the account according to Account.DeletePolicy
+func (s *PostgresStorage) DeleteUser(ctx context.Context, userID int, pass string) error {
+	ctx, span := s.startSpan(ctx, "DeleteUser")
+	defer span.End()
+
+	tx, err := s.Postgres.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	queriesWithTX := s.queries(tx)
+
+	if err = s.verifyPassword(ctx, queriesWithTX, userID, pass); err != nil {
+		return err
+	}
+
+	switch s.cfg.Account.DeletePolicy {
+	case "", DeletePolicyAnonymize:
+		err = s.anonymizeUser(ctx, queriesWithTX, int32(userID))
+	case DeletePolicyCascade:
+		err = s.cascadeUser(ctx, queriesWithTX, int32(userID))
+	default:
+		err = errors.Wrapf(ErrUnknownDeletePolicy, "%q", s.cfg.Account.DeletePolicy)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit transaction")
+	}
+
+	s.log.Infof("user %d deleted, policy %s", userID, s.cfg.Account.DeletePolicy)
+
+	return nil
+}
+
+func (s *PostgresStorage) anonymizeUser(ctx context.Context, q *db.Queries, userID int32) error {
+	balance, err := q.GetLedgerBalance(ctx, sql.NullInt32{Int32: userID, Valid: true})
+	if err != nil {
+		return errors.Wrap(err, "get ledger balance")
+	}
+
+	//write off
+	if balance.Current != 0 {
+		err = post(ctx, q, posting{
+			userID:  userID,
+			kind:    LedgerAdjustment,
+			account: AccountAdjustments,
+			amount:  -balance.Current,
+			reason:  "account deleted",
+		})
+		if err != nil {
+			return errors.Wrap(err, "write off balance")
+		}
+	}
+
+	login, err := anonymousLogin(userID)
+	if err != nil {
+		return err
+	}
+
+	err = q.AnonymizeUser(ctx, db.AnonymizeUserParams{
+		ID:        userID,
+		Login:     login,
+		DeletedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	if err != nil {
+		return errors.Wrap(err, "anonymize user")
+	}
+
+	err = q.RevokeUserRefreshTokens(ctx, db.RevokeUserRefreshTokensParams{
+		UserID:    userID,
+		RevokedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	if err != nil {
+		return errors.Wrap(err, "revoke refresh tokens")
+	}
+
+	return nil
+}
+
+// cascadeUser ledger is removed explicitly, other tables cascade on users FK
+func (s *PostgresStorage) cascadeUser(ctx context.Context, q *db.Queries, userID int32) error {
+	_, err := q.DeleteUserLedger(ctx, sql.NullInt32{Int32: userID, Valid: true})
+	if err != nil {
+		return errors.Wrap(err, "delete user ledger")
+	}
+
+	if err = q.DeleteUser(ctx, userID); err != nil {
+		return errors.Wrap(err, "delete user")
+	}
+
+	return nil
+}
+
+// RevokeUserSessions revokes refresh tokens of every session but the given one
+func (s *PostgresStorage) RevokeUserSessions(ctx context.Context, userID int, exceptFamilyID string) error {
+	ctx, span := s.startSpan(ctx, "RevokeUserSessions")
+	defer span.End()
+
+	err := s.Queries.RevokeUserRefreshTokens(ctx, db.RevokeUserRefreshTokensParams{
+		UserID:    int32(userID),
+		FamilyID:  exceptFamilyID,
+		RevokedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	if err != nil {
+		return errors.Wrap(err, "revoke user refresh tokens")
+	}
+
+	return nil
+}
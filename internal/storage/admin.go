@@ -0,0 +1,182 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage/db"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+// RoleRank orders roles, unknown role has rank 0
+func RoleRank(role string) int {
+	switch role {
+	case RoleUser:
+		return 1
+	case RoleSupport:
+		return 2
+	case RoleAdmin:
+		return 3
+	}
+
+	return 0
+}
+
+func (s *PostgresStorage) UserRole(ctx context.Context, userID int) (string, error) {
+	ctx, span := s.startSpan(ctx, "UserRole")
+	defer span.End()
+
+	role, err := s.Queries.GetUserRole(ctx, int32(userID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", entities.ErrNotFound
+		}
+		return "", errors.Wrap(err, "get user role")
+	}
+
+	return role, nil
+}
+
+func (s *PostgresStorage) SetUserRole(ctx context.Context, userID int, role string) error {
+	ctx, span := s.startSpan(ctx, "SetUserRole")
+	defer span.End()
+
+	if RoleRank(role) == 0 {
+		return errors.Wrapf(ErrUnknownRole, "%q", role)
+	}
+
+	updated, err := s.Queries.SetUserRole(ctx, db.SetUserRoleParams{
+		ID:   int32(userID),
+		Role: role,
+	})
+	if err != nil {
+		return errors.Wrap(err, "set user role")
+	}
+
+	if updated == 0 {
+		return entities.ErrNotFound
+	}
+
+	return nil
+}
+
+// promoteAdmin grants admin role to the configured login, so the first
+// admin doesn't need to be created by hand
+func (s *PostgresStorage) promoteAdmin(ctx context.Context, login string) error {
+	updated, err := s.Queries.SetUserRoleByLogin(ctx, db.SetUserRoleByLoginParams{
+		Login: login,
+		Role:  RoleAdmin,
+	})
+	if err != nil {
+		return errors.Wrap(err, "set user role by login")
+	}
+
+	if updated == 0 {
+		s.log.Warnf("admin %q is not registered yet", login)
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) UserByLogin(ctx context.Context, login string) (User, error) {
+	ctx, span := s.startSpan(ctx, "UserByLogin")
+	defer span.End()
+
+	user, err := s.Queries.GetUserByLogin(ctx, login)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, entities.ErrNotFound
+		}
+		return User{}, errors.Wrap(err, "get user by login")
+	}
+
+	return User{
+		Login: user.Login,
+		ID:    int(user.ID),
+		Role:  user.Role,
+		Balance: UserBalance{
+			Current:   user.BalanceCurrent,
+			Withdrawn: user.BalanceWithdrawn,
+		},
+	}, nil
+}
+
+func (s *PostgresStorage) Order(ctx context.Context, number string) (Order, error) {
+	ctx, span := s.startSpan(ctx, "Order")
+	defer span.End()
+
+	order, err := s.Queries.GetOrderByNumber(ctx, number)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return Order{}, entities.ErrNotFound
+		}
+		return Order{}, errors.Wrap(err, "get order by number")
+	}
+
+	return Order{
+		UserID:     int(order.UserID),
+		Number:     order.Number,
+		Status:     order.Status,
+		Accrual:    order.Accrual,
+		UploadedAt: order.UploadedAt.Format(time.RFC3339),
+	}, nil
+}
+
+func (s *PostgresStorage) WriteAudit(ctx context.Context, record AuditRecord) error {
+	ctx, span := s.startSpan(ctx, "WriteAudit")
+	defer span.End()
+
+	return writeAudit(ctx, s.Queries, record)
+}
+
+func writeAudit(ctx context.Context, q *db.Queries, record AuditRecord) error {
+	details := record.Details
+	if len(details) == 0 {
+		details = []byte("{}")
+	}
+
+	err := q.CreateAuditRecord(ctx, db.CreateAuditRecordParams{
+		ActorID:      sql.NullInt32{Int32: int32(record.ActorID), Valid: record.ActorID != 0},
+		Action:       record.Action,
+		TargetUserID: sql.NullInt32{Int32: int32(record.TargetUserID), Valid: record.TargetUserID != 0},
+		Details:      details,
+	})
+	if err != nil {
+		return errors.Wrap(err, "create audit record")
+	}
+
+	return nil
+}
+
+// AuditLog returns latest records, targetUserID 0 means any user
+func (s *PostgresStorage) AuditLog(ctx context.Context, targetUserID int, limit int) ([]AuditRecord, error) {
+	ctx, span := s.startSpan(ctx, "AuditLog")
+	defer span.End()
+
+	rows, err := s.Queries.GetAuditLog(ctx, db.GetAuditLogParams{
+		Limit:        int32(limit),
+		TargetUserID: sql.NullInt32{Int32: int32(targetUserID), Valid: targetUserID != 0},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "get audit log")
+	}
+
+	var records []AuditRecord
+
+	for _, row := range rows {
+		records = append(records, AuditRecord{
+			ID:           row.ID,
+			ActorID:      int(row.ActorID.Int32),
+			Action:       row.Action,
+			TargetUserID: int(row.TargetUserID.Int32),
+			Details:      row.Details,
+			CreatedAt:    row.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return records, nil
+}
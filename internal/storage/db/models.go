@@ -3,29 +3,95 @@
 package db
 
 import (
+	"database/sql"
+	"encoding/json"
 	"time"
+
+	"github.com/wickedv43/yd-diploma/internal/entities"
 )
 
+type AuditLog struct {
+	ID           int64
+	ActorID      sql.NullInt32
+	Action       string
+	TargetUserID sql.NullInt32
+	Details      json.RawMessage
+	CreatedAt    time.Time
+}
+
 type Bill struct {
 	ID          int32
 	OrderNumber string
 	UserID      int32
-	Sum         int32
+	Sum         entities.Points
 	ProcessedAt time.Time
 }
 
+type IdempotencyKey struct {
+	Key          string
+	UserID       int32
+	RequestHash  string
+	StatusCode   int32
+	ContentType  string
+	ResponseBody []byte
+	CreatedAt    time.Time
+	ExpiresAt    time.Time
+}
+
+type LedgerEntry struct {
+	ID            int64
+	TransactionID int64
+	Account       string
+	UserID        sql.NullInt32
+	Amount        entities.Points
+}
+
+type LedgerTransaction struct {
+	ID          int64
+	Kind        string
+	OrderNumber sql.NullString
+	ReversesID  sql.NullInt64
+	Reason      string
+	CreatedAt   time.Time
+}
+
+type LoginAttempt struct {
+	Key           string
+	Failures      int32
+	LastFailureAt time.Time
+	LockedUntil   sql.NullTime
+}
+
 type Order struct {
 	Number     string
 	UserID     int32
 	Status     string
-	Accrual    int32
+	Accrual    entities.Points
 	UploadedAt time.Time
 }
 
+type RefreshToken struct {
+	ID        int64
+	TokenHash string
+	FamilyID  string
+	UserID    int32
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+	RevokedAt sql.NullTime
+	CreatedAt time.Time
+}
+
+type RevokedToken struct {
+	Jti       string
+	ExpiresAt time.Time
+}
+
 type User struct {
 	ID               int32
 	Login            string
 	Password         string
-	BalanceCurrent   int32
-	BalanceWithdrawn int32
+	BalanceCurrent   entities.Points
+	BalanceWithdrawn entities.Points
+	DeletedAt        sql.NullTime
+	Role             string
 }
@@ -6,8 +6,25 @@ package db
 import (
 	"context"
 	"time"
+
+	"github.com/wickedv43/yd-diploma/internal/entities"
 )
 
+const countUnprocessedOrders = `-- name: CountUnprocessedOrders :one
+SELECT count(*)
+FROM orders o
+JOIN users u ON u.id = o.user_id
+WHERE o.status IN ('NEW', 'PROCESSING')
+  AND u.deleted_at IS NULL
+`
+
+func (q *Queries) CountUnprocessedOrders(ctx context.Context) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUnprocessedOrders)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createBill = `-- name: CreateBill :one
 INSERT INTO bills (order_number, user_id, sum, processed_at)
 VALUES ($1, $2, $3, $4)
@@ -17,7 +34,7 @@ VALUES ($1, $2, $3, $4)
 type CreateBillParams struct {
 	OrderNumber string
 	UserID      int32
-	Sum         int32
+	Sum         entities.Points
 	ProcessedAt time.Time
 }
 
@@ -49,7 +66,7 @@ type CreateOrderParams struct {
 	Number     string
 	UserID     int32
 	Status     string
-	Accrual    int32
+	Accrual    entities.Points
 	UploadedAt time.Time
 }
 
@@ -76,31 +93,41 @@ const createUser = `-- name: CreateUser :one
 
 INSERT INTO users (login, password, balance_current, balance_withdrawn)
 VALUES ($1, $2, $3, $4)
-    RETURNING id, login, password, balance_current, balance_withdrawn
+    RETURNING id, login, password, balance_current, balance_withdrawn, role
 `
 
 type CreateUserParams struct {
 	Login            string
 	Password         string
-	BalanceCurrent   int32
-	BalanceWithdrawn int32
+	BalanceCurrent   entities.Points
+	BalanceWithdrawn entities.Points
+}
+
+type CreateUserRow struct {
+	ID               int32
+	Login            string
+	Password         string
+	BalanceCurrent   entities.Points
+	BalanceWithdrawn entities.Points
+	Role             string
 }
 
 // queries/queries.sql
-func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, error) {
+func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (CreateUserRow, error) {
 	row := q.db.QueryRowContext(ctx, createUser,
 		arg.Login,
 		arg.Password,
 		arg.BalanceCurrent,
 		arg.BalanceWithdrawn,
 	)
-	var i User
+	var i CreateUserRow
 	err := row.Scan(
 		&i.ID,
 		&i.Login,
 		&i.Password,
 		&i.BalanceCurrent,
 		&i.BalanceWithdrawn,
+		&i.Role,
 	)
 	return i, err
 }
@@ -159,42 +186,6 @@ func (q *Queries) GetBillByID(ctx context.Context, id int32) (Bill, error) {
 	return i, err
 }
 
-const getBillsByUserID = `-- name: GetBillsByUserID :many
-SELECT id, order_number, user_id, sum, processed_at
-FROM bills
-WHERE user_id = $1
-ORDER BY processed_at DESC
-`
-
-func (q *Queries) GetBillsByUserID(ctx context.Context, userID int32) ([]Bill, error) {
-	rows, err := q.db.QueryContext(ctx, getBillsByUserID, userID)
-	if err != nil {
-		return nil, err
-	}
-	defer rows.Close()
-	var items []Bill
-	for rows.Next() {
-		var i Bill
-		if err := rows.Scan(
-			&i.ID,
-			&i.OrderNumber,
-			&i.UserID,
-			&i.Sum,
-			&i.ProcessedAt,
-		); err != nil {
-			return nil, err
-		}
-		items = append(items, i)
-	}
-	if err := rows.Close(); err != nil {
-		return nil, err
-	}
-	if err := rows.Err(); err != nil {
-		return nil, err
-	}
-	return items, nil
-}
-
 const getOrderByNumber = `-- name: GetOrderByNumber :one
 SELECT number, user_id, status, accrual, uploaded_at
 FROM orders
@@ -214,14 +205,38 @@ func (q *Queries) GetOrderByNumber(ctx context.Context, number string) (Order, e
 	return i, err
 }
 
-const getOrdersByUserID = `-- name: GetOrdersByUserID :many
+const getOrderByNumberForUpdate = `-- name: GetOrderByNumberForUpdate :one
 SELECT number, user_id, status, accrual, uploaded_at
 FROM orders
-WHERE user_id = $1
+WHERE number = $1
+FOR UPDATE
 `
 
-func (q *Queries) GetOrdersByUserID(ctx context.Context, userID int32) ([]Order, error) {
-	rows, err := q.db.QueryContext(ctx, getOrdersByUserID, userID)
+func (q *Queries) GetOrderByNumberForUpdate(ctx context.Context, number string) (Order, error) {
+	row := q.db.QueryRowContext(ctx, getOrderByNumberForUpdate, number)
+	var i Order
+	err := row.Scan(
+		&i.Number,
+		&i.UserID,
+		&i.Status,
+		&i.Accrual,
+		&i.UploadedAt,
+	)
+	return i, err
+}
+
+const getUnprocessedOrders = `-- name: GetUnprocessedOrders :many
+SELECT o.number, o.user_id, o.status, o.accrual, o.uploaded_at
+FROM orders o
+JOIN users u ON u.id = o.user_id
+WHERE o.status IN ('NEW', 'PROCESSING')
+  AND u.deleted_at IS NULL
+ORDER BY o.uploaded_at
+LIMIT $1
+`
+
+func (q *Queries) GetUnprocessedOrders(ctx context.Context, limit int32) ([]Order, error) {
+	rows, err := q.db.QueryContext(ctx, getUnprocessedOrders, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -249,44 +264,103 @@ func (q *Queries) GetOrdersByUserID(ctx context.Context, userID int32) ([]Order,
 	return items, nil
 }
 
+const getUserBalance = `-- name: GetUserBalance :one
+SELECT balance_current, balance_withdrawn
+FROM users
+WHERE id = $1
+  AND deleted_at IS NULL
+`
+
+type GetUserBalanceRow struct {
+	BalanceCurrent   entities.Points
+	BalanceWithdrawn entities.Points
+}
+
+func (q *Queries) GetUserBalance(ctx context.Context, id int32) (GetUserBalanceRow, error) {
+	row := q.db.QueryRowContext(ctx, getUserBalance, id)
+	var i GetUserBalanceRow
+	err := row.Scan(&i.BalanceCurrent, &i.BalanceWithdrawn)
+	return i, err
+}
+
 const getUserByID = `-- name: GetUserByID :one
-SELECT id, login, password, balance_current, balance_withdrawn
+SELECT id, login, password, balance_current, balance_withdrawn, role
 FROM users
 WHERE id = $1
+  AND deleted_at IS NULL
 `
 
-func (q *Queries) GetUserByID(ctx context.Context, id int32) (User, error) {
+type GetUserByIDRow struct {
+	ID               int32
+	Login            string
+	Password         string
+	BalanceCurrent   entities.Points
+	BalanceWithdrawn entities.Points
+	Role             string
+}
+
+func (q *Queries) GetUserByID(ctx context.Context, id int32) (GetUserByIDRow, error) {
 	row := q.db.QueryRowContext(ctx, getUserByID, id)
-	var i User
+	var i GetUserByIDRow
 	err := row.Scan(
 		&i.ID,
 		&i.Login,
 		&i.Password,
 		&i.BalanceCurrent,
 		&i.BalanceWithdrawn,
+		&i.Role,
 	)
 	return i, err
 }
 
 const getUserByLogin = `-- name: GetUserByLogin :one
-SELECT id, login, password, balance_current, balance_withdrawn
+SELECT id, login, password, balance_current, balance_withdrawn, role
 FROM users
 WHERE login = $1
+  AND deleted_at IS NULL
 `
 
-func (q *Queries) GetUserByLogin(ctx context.Context, login string) (User, error) {
+type GetUserByLoginRow struct {
+	ID               int32
+	Login            string
+	Password         string
+	BalanceCurrent   entities.Points
+	BalanceWithdrawn entities.Points
+	Role             string
+}
+
+func (q *Queries) GetUserByLogin(ctx context.Context, login string) (GetUserByLoginRow, error) {
 	row := q.db.QueryRowContext(ctx, getUserByLogin, login)
-	var i User
+	var i GetUserByLoginRow
 	err := row.Scan(
 		&i.ID,
 		&i.Login,
 		&i.Password,
 		&i.BalanceCurrent,
 		&i.BalanceWithdrawn,
+		&i.Role,
 	)
 	return i, err
 }
 
+const updateOrderAccrual = `-- name: UpdateOrderAccrual :exec
+UPDATE orders
+SET status = $2,
+    accrual = $3
+WHERE number = $1
+`
+
+type UpdateOrderAccrualParams struct {
+	Number  string
+	Status  string
+	Accrual entities.Points
+}
+
+func (q *Queries) UpdateOrderAccrual(ctx context.Context, arg UpdateOrderAccrualParams) error {
+	_, err := q.db.ExecContext(ctx, updateOrderAccrual, arg.Number, arg.Status, arg.Accrual)
+	return err
+}
+
 const updateOrderStatus = `-- name: UpdateOrderStatus :exec
 UPDATE orders
 SET status = $2
@@ -303,20 +377,18 @@ func (q *Queries) UpdateOrderStatus(ctx context.Context, arg UpdateOrderStatusPa
 	return err
 }
 
-const updateUserBalance = `-- name: UpdateUserBalance :exec
+const updateUserPassword = `-- name: UpdateUserPassword :exec
 UPDATE users
-SET balance_current = $2,
-    balance_withdrawn = $3
+SET password = $2
 WHERE id = $1
 `
 
-type UpdateUserBalanceParams struct {
-	ID               int32
-	BalanceCurrent   int32
-	BalanceWithdrawn int32
+type UpdateUserPasswordParams struct {
+	ID       int32
+	Password string
 }
 
-func (q *Queries) UpdateUserBalance(ctx context.Context, arg UpdateUserBalanceParams) error {
-	_, err := q.db.ExecContext(ctx, updateUserBalance, arg.ID, arg.BalanceCurrent, arg.BalanceWithdrawn)
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.Password)
 	return err
 }
@@ -0,0 +1,95 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage/db"
+)
+
+// ReserveIdempotencyKey stores a new key for the user. When the key is taken
+// and not expired, the stored one is returned with entities.ErrAlreadyExists.
+func (s *PostgresStorage) ReserveIdempotencyKey(ctx context.Context, key IdempotencyKey) (IdempotencyKey, error) {
+	ctx, span := s.startSpan(ctx, "ReserveIdempotencyKey")
+	defer span.End()
+
+	created, err := s.Queries.CreateIdempotencyKey(ctx, db.CreateIdempotencyKeyParams{
+		Key:         key.Key,
+		UserID:      int32(key.UserID),
+		RequestHash: key.RequestHash,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   key.ExpiresAt,
+	})
+	if err != nil {
+		return IdempotencyKey{}, errors.Wrap(err, "create idempotency key")
+	}
+
+	if created == 1 {
+		return key, nil
+	}
+
+	stored, err := s.Queries.GetIdempotencyKey(ctx, db.GetIdempotencyKeyParams{
+		Key:    key.Key,
+		UserID: int32(key.UserID),
+	})
+	if err != nil {
+		return IdempotencyKey{}, errors.Wrap(err, "get idempotency key")
+	}
+
+	return IdempotencyKey{
+		Key:         stored.Key,
+		UserID:      int(stored.UserID),
+		RequestHash: stored.RequestHash,
+		StatusCode:  int(stored.StatusCode),
+		ContentType: stored.ContentType,
+		Response:    stored.ResponseBody,
+		ExpiresAt:   stored.ExpiresAt,
+	}, entities.ErrAlreadyExists
+}
+
+func (s *PostgresStorage) SaveIdempotencyResponse(ctx context.Context, key IdempotencyKey) error {
+	ctx, span := s.startSpan(ctx, "SaveIdempotencyResponse")
+	defer span.End()
+
+	err := s.Queries.SaveIdempotencyResponse(ctx, db.SaveIdempotencyResponseParams{
+		Key:          key.Key,
+		UserID:       int32(key.UserID),
+		StatusCode:   int32(key.StatusCode),
+		ContentType:  key.ContentType,
+		ResponseBody: key.Response,
+	})
+	if err != nil {
+		return errors.Wrap(err, "save idempotency response")
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) DeleteIdempotencyKey(ctx context.Context, key string, userID int) error {
+	ctx, span := s.startSpan(ctx, "DeleteIdempotencyKey")
+	defer span.End()
+
+	err := s.Queries.DeleteIdempotencyKey(ctx, db.DeleteIdempotencyKeyParams{
+		Key:    key,
+		UserID: int32(userID),
+	})
+	if err != nil {
+		return errors.Wrap(err, "delete idempotency key")
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := s.startSpan(ctx, "DeleteExpiredIdempotencyKeys")
+	defer span.End()
+
+	deleted, err := s.Queries.DeleteExpiredIdempotencyKeys(ctx, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete expired idempotency keys")
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wickedv43/yd-diploma/internal/storage/db"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// sqlc puts "-- name: Query :kind" first in every query
+const queryNamePrefix = "-- name: "
+
+// instrumentedDB measures and traces queries of sqlc. Rows are read after
+// QueryContext returns, so for :many queries it's time to the first row
+type instrumentedDB struct {
+	db       db.DBTX
+	duration *prometheus.HistogramVec
+	tracer   trace.Tracer
+}
+
+// queries is db.New instrumenting every query, dbtx is the pool or a transaction
+func (s *PostgresStorage) queries(dbtx db.DBTX) *db.Queries {
+	return db.New(instrumentedDB{db: dbtx, duration: s.queryDuration, tracer: s.tracer})
+}
+
+// startSpan is a span of PostgresStorage method, queries are its children
+func (s *PostgresStorage) startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return s.tracer.Start(ctx, "PostgresStorage."+method)
+}
+
+// start begins a query span, end must be called with the query error
+func (d instrumentedDB) start(ctx context.Context, query string) (context.Context, func(error)) {
+	name := queryName(query)
+	start := time.Now()
+
+	ctx, span := d.tracer.Start(ctx, name,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			semconv.DBSystemPostgreSQL,
+			attribute.String("db.operation.name", name),
+		),
+	)
+
+	return ctx, func(err error) {
+		d.duration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
+
+func (d instrumentedDB) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	ctx, end := d.start(ctx, query)
+	res, err := d.db.ExecContext(ctx, query, args...)
+	end(err)
+
+	return res, err
+}
+
+func (d instrumentedDB) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return d.db.PrepareContext(ctx, query)
+}
+
+func (d instrumentedDB) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, end := d.start(ctx, query)
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	end(err)
+
+	return rows, err
+}
+
+func (d instrumentedDB) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	ctx, end := d.start(ctx, query)
+	row := d.db.QueryRowContext(ctx, query, args...)
+	end(row.Err())
+
+	return row
+}
+
+func queryName(query string) string {
+	if !strings.HasPrefix(query, queryNamePrefix) {
+		return "unknown"
+	}
+
+	name, _, _ := strings.Cut(query[len(queryNamePrefix):], " ")
+
+	return name
+}
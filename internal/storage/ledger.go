@@ -0,0 +1,253 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage/db"
+)
+
+// posting is a ledger transaction with two legs: user account gets amount,
+// counter account gets -amount, so every transaction sums to zero
+type posting struct {
+	userID  int32
+	kind    string
+	account string
+	amount  entities.Points
+	// withdrawn is a change of the materialized withdrawn total
+	withdrawn   entities.Points
+	orderNumber string
+	reversesID  int64
+	reason      string
+}
+
+// post appends a transaction to the ledger and materializes user balance,
+// it must be called inside sql transaction
+func post(ctx context.Context, q *db.Queries, p posting) error {
+	tx, err := q.CreateLedgerTransaction(ctx, db.CreateLedgerTransactionParams{
+		Kind:        p.kind,
+		OrderNumber: sql.NullString{String: p.orderNumber, Valid: p.orderNumber != ""},
+		ReversesID:  sql.NullInt64{Int64: p.reversesID, Valid: p.reversesID != 0},
+		Reason:      p.reason,
+	})
+	if err != nil {
+		return errors.Wrap(err, "create ledger transaction")
+	}
+
+	//user leg
+	err = q.CreateLedgerEntry(ctx, db.CreateLedgerEntryParams{
+		TransactionID: tx.ID,
+		Account:       AccountUser,
+		UserID:        sql.NullInt32{Int32: p.userID, Valid: true},
+		Amount:        p.amount,
+	})
+	if err != nil {
+		return errors.Wrap(err, "create user ledger entry")
+	}
+
+	//counter leg
+	err = q.CreateLedgerEntry(ctx, db.CreateLedgerEntryParams{
+		TransactionID: tx.ID,
+		Account:       p.account,
+		Amount:        -p.amount,
+	})
+	if err != nil {
+		return errors.Wrap(err, "create counter ledger entry")
+	}
+
+	//balance can't go below zero
+	_, err = q.AddUserBalance(ctx, db.AddUserBalanceParams{
+		ID:        p.userID,
+		Current:   p.amount,
+		Withdrawn: p.withdrawn,
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.ErrHaveEnoughMoney
+		}
+		return errors.Wrap(err, "add user balance")
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) Ledger(ctx context.Context, userID int) ([]LedgerEntry, error) {
+	ctx, span := s.startSpan(ctx, "Ledger")
+	defer span.End()
+
+	rows, err := s.Queries.GetLedgerByUserID(ctx, sql.NullInt32{Int32: int32(userID), Valid: true})
+	if err != nil {
+		return nil, errors.Wrap(err, "get ledger by user id")
+	}
+
+	var entries []LedgerEntry
+
+	for _, row := range rows {
+		entries = append(entries, LedgerEntry{
+			ID:          row.ID,
+			Kind:        row.Kind,
+			Amount:      row.Amount,
+			OrderNumber: row.OrderNumber.String,
+			ReversesID:  row.ReversesID.Int64,
+			Reason:      row.Reason,
+			CreatedAt:   row.CreatedAt.Format(time.RFC3339),
+		})
+	}
+
+	return entries, nil
+}
+
+func (s *PostgresStorage) AdjustBalance(ctx context.Context, userID int, amount entities.Points, reason string, audit AuditRecord) error {
+	ctx, span := s.startSpan(ctx, "AdjustBalance")
+	defer span.End()
+
+	tx, err := s.Postgres.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	queriesWithTX := s.queries(tx)
+
+	err = post(ctx, queriesWithTX, posting{
+		userID:  int32(userID),
+		kind:    LedgerAdjustment,
+		account: AccountAdjustments,
+		amount:  amount,
+		reason:  reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	//money doesn't move without a trace
+	if err = writeAudit(ctx, queriesWithTX, audit); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit transaction")
+	}
+
+	return nil
+}
+
+// ReverseTransaction appends a transaction with negated legs of the given one
+func (s *PostgresStorage) ReverseTransaction(ctx context.Context, id int64, reason string) error {
+	ctx, span := s.startSpan(ctx, "ReverseTransaction")
+	defer span.End()
+
+	tx, err := s.Postgres.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	queriesWithTX := s.queries(tx)
+
+	original, err := queriesWithTX.GetLedgerTransactionForUpdate(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.ErrNotFound
+		}
+		return errors.Wrap(err, "get ledger transaction")
+	}
+
+	if original.Kind == LedgerReversal {
+		return errors.Wrap(entities.ErrConflict, "reversal can't be reversed")
+	}
+
+	//original is locked, a concurrent reversal is either committed or waits
+	_, err = queriesWithTX.GetLedgerReversal(ctx, sql.NullInt64{Int64: id, Valid: true})
+	if err == nil {
+		return errors.Wrap(entities.ErrConflict, "transaction already reversed")
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return errors.Wrap(err, "get ledger reversal")
+	}
+
+	entries, err := queriesWithTX.GetLedgerEntriesByTransactionID(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, "get ledger entries")
+	}
+
+	p := posting{
+		kind:        LedgerReversal,
+		orderNumber: original.OrderNumber.String,
+		reversesID:  original.ID,
+		reason:      reason,
+	}
+
+	for _, e := range entries {
+		if e.Account == AccountUser {
+			p.userID = e.UserID.Int32
+			p.amount = -e.Amount
+		} else {
+			p.account = e.Account
+		}
+	}
+
+	if original.Kind == LedgerWithdrawal {
+		p.withdrawn = -p.amount
+	}
+
+	if err = post(ctx, queriesWithTX, p); err != nil {
+		if isUniqueViolation(err) {
+			return errors.Wrap(entities.ErrConflict, "transaction already reversed")
+		}
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit transaction")
+	}
+
+	return nil
+}
+
+// CheckLedger recomputes every balance from the ledger and returns
+// users with a different stored balance and transactions not summing to zero
+func (s *PostgresStorage) CheckLedger(ctx context.Context) ([]LedgerMismatch, error) {
+	ctx, span := s.startSpan(ctx, "CheckLedger")
+	defer span.End()
+
+	users, err := s.Queries.GetLedgerMismatches(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get ledger mismatches")
+	}
+
+	var mismatches []LedgerMismatch
+
+	for _, u := range users {
+		mismatches = append(mismatches, LedgerMismatch{
+			UserID: int(u.ID),
+			Stored: UserBalance{
+				Current:   u.BalanceCurrent,
+				Withdrawn: u.BalanceWithdrawn,
+			},
+			Derived: UserBalance{
+				Current:   u.LedgerCurrent,
+				Withdrawn: u.LedgerWithdrawn,
+			},
+		})
+	}
+
+	transactions, err := s.Queries.GetUnbalancedLedgerTransactions(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "get unbalanced ledger transactions")
+	}
+
+	for _, t := range transactions {
+		mismatches = append(mismatches, LedgerMismatch{
+			TransactionID: t.TransactionID,
+			Derived: UserBalance{
+				Current: t.Total,
+			},
+		})
+	}
+
+	return mismatches, nil
+}
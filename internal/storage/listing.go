@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wickedv43/yd-diploma/internal/storage/db"
+)
+
+// page sizes of ListQuery
+const (
+	DefaultPageSize = 50
+	MaxPageSize     = 100
+	// allRows is the size of a query without Limit and Cursor, one more
+	// row still fits int32
+	allRows = math.MaxInt32 - 1
+)
+
+var ErrBadCursor = errors.New("bad cursor")
+
+// cursor is the last row of a page, rows are ordered by (at, number)
+type cursor struct {
+	at     time.Time
+	number string
+}
+
+func (c cursor) String() string {
+	raw := c.at.UTC().Format(time.RFC3339Nano) + "|" + c.number
+
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// parseCursor returns zero cursor for the first page
+func parseCursor(s string) (cursor, error) {
+	if s == "" {
+		return cursor{}, nil
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return cursor{}, ErrBadCursor
+	}
+
+	at, number, ok := strings.Cut(string(raw), "|")
+	if !ok {
+		return cursor{}, ErrBadCursor
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, at)
+	if err != nil {
+		return cursor{}, ErrBadCursor
+	}
+
+	return cursor{at: t, number: number}, nil
+}
+
+// rowLess orders rows by (at, number) like the sql row comparison
+func rowLess(at1 time.Time, number1 string, at2 time.Time, number2 string) bool {
+	if !at1.Equal(at2) {
+		return at1.Before(at2)
+	}
+
+	return number1 < number2
+}
+
+// after reports whether row (at, number) goes after c in the page order
+func (c cursor) after(at time.Time, number string, asc bool) bool {
+	if c.at.IsZero() {
+		return true
+	}
+
+	if asc {
+		return rowLess(c.at, c.number, at, number)
+	}
+	return rowLess(at, number, c.at, c.number)
+}
+
+// pageSize clamps the limit of q. Query without limit and cursor lists
+// every row like it did before pagination, clients of the spec don't
+// follow pages.
+func (q ListQuery) pageSize() int {
+	if q.Limit <= 0 {
+		if q.Cursor == "" {
+			return allRows
+		}
+		return DefaultPageSize
+	}
+
+	return min(q.Limit, MaxPageSize)
+}
+
+// inRange checks From and To filters of q
+func (q ListQuery) inRange(t time.Time) bool {
+	if !q.From.IsZero() && t.Before(q.From) {
+		return false
+	}
+
+	return q.To.IsZero() || t.Before(q.To)
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func (s *PostgresStorage) ListOrders(ctx context.Context, q ListQuery) (OrderPage, error) {
+	ctx, span := s.startSpan(ctx, "ListOrders")
+	defer span.End()
+
+	after, err := parseCursor(q.Cursor)
+	if err != nil {
+		return OrderPage{}, err
+	}
+
+	size := q.pageSize()
+
+	//one more row tells if there is a next page
+	var ordersPG []db.Order
+	if q.Asc {
+		ordersPG, err = s.Queries.ListOrdersAsc(ctx, db.ListOrdersAscParams{
+			UserID:      int32(q.UserID),
+			Statuses:    q.Statuses,
+			FromTime:    nullTime(q.From),
+			ToTime:      nullTime(q.To),
+			AfterTime:   nullTime(after.at),
+			AfterNumber: after.number,
+			PageSize:    int32(size + 1),
+		})
+	} else {
+		ordersPG, err = s.Queries.ListOrdersDesc(ctx, db.ListOrdersDescParams{
+			UserID:      int32(q.UserID),
+			Statuses:    q.Statuses,
+			FromTime:    nullTime(q.From),
+			ToTime:      nullTime(q.To),
+			AfterTime:   nullTime(after.at),
+			AfterNumber: after.number,
+			PageSize:    int32(size + 1),
+		})
+	}
+	if err != nil {
+		return OrderPage{}, errors.Wrap(err, "list orders")
+	}
+
+	var page OrderPage
+
+	if len(ordersPG) > size {
+		ordersPG = ordersPG[:size]
+		last := ordersPG[size-1]
+		page.NextCursor = cursor{at: last.UploadedAt, number: last.Number}.String()
+	}
+
+	for _, order := range ordersPG {
+		page.Orders = append(page.Orders, Order{
+			UserID:     int(order.UserID),
+			Number:     order.Number,
+			Status:     order.Status,
+			Accrual:    order.Accrual,
+			UploadedAt: order.UploadedAt.Format(time.RFC3339),
+		})
+	}
+
+	return page, nil
+}
+
+func (s *PostgresStorage) ListWithdrawals(ctx context.Context, q ListQuery) (BillPage, error) {
+	ctx, span := s.startSpan(ctx, "ListWithdrawals")
+	defer span.End()
+
+	after, err := parseCursor(q.Cursor)
+	if err != nil {
+		return BillPage{}, err
+	}
+
+	size := q.pageSize()
+
+	//asc and desc rows are the same struct
+	var billsPG []db.ListBillsDescRow
+	if q.Asc {
+		var rows []db.ListBillsAscRow
+		rows, err = s.Queries.ListBillsAsc(ctx, db.ListBillsAscParams{
+			UserID:      int32(q.UserID),
+			FromTime:    nullTime(q.From),
+			ToTime:      nullTime(q.To),
+			AfterTime:   nullTime(after.at),
+			AfterNumber: after.number,
+			PageSize:    int32(size + 1),
+		})
+		for _, row := range rows {
+			billsPG = append(billsPG, db.ListBillsDescRow(row))
+		}
+	} else {
+		billsPG, err = s.Queries.ListBillsDesc(ctx, db.ListBillsDescParams{
+			UserID:      int32(q.UserID),
+			FromTime:    nullTime(q.From),
+			ToTime:      nullTime(q.To),
+			AfterTime:   nullTime(after.at),
+			AfterNumber: after.number,
+			PageSize:    int32(size + 1),
+		})
+	}
+	if err != nil {
+		return BillPage{}, errors.Wrap(err, "list bills")
+	}
+
+	var page BillPage
+
+	if len(billsPG) > size {
+		billsPG = billsPG[:size]
+		last := billsPG[size-1]
+		page.NextCursor = cursor{at: last.ProcessedAt, number: last.OrderNumber}.String()
+	}
+
+	for _, bill := range billsPG {
+		page.Bills = append(page.Bills, Bill{
+			UserID:      int(bill.UserID),
+			Order:       bill.OrderNumber,
+			Sum:         bill.Sum,
+			ProcessedAt: bill.ProcessedAt.Format(time.RFC3339),
+		})
+	}
+
+	return page, nil
+}
@@ -0,0 +1,1221 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/password"
+	"github.com/wickedv43/yd-diploma/internal/tracing"
+	"github.com/wickedv43/yd-diploma/internal/util"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// MemoryStorage keeps everything in process memory with the same semantics
+// as PostgresStorage. All data is guarded by one mutex, so every method is
+// atomic like a sql transaction.
+type MemoryStorage struct {
+	mu sync.RWMutex
+
+	users       map[int]*memUser
+	logins      map[string]int
+	orders      map[string]*memOrder
+	bills       map[string]*memBill
+	ledger      []*memTransaction
+	idempotency map[memIdempotencyID]IdempotencyKey
+	refresh     map[string]*memRefreshToken
+	revoked     map[string]time.Time
+	audit       []AuditRecord
+
+	nextUserID        int
+	nextTransactionID int64
+	nextAuditID       int64
+
+	cfg    *config.Config
+	log    *logrus.Entry
+	hasher *password.Manager
+	tracer trace.Tracer
+}
+
+type memUser struct {
+	id        int
+	login     string
+	password  string
+	role      string
+	balance   UserBalance
+	deletedAt time.Time
+}
+
+func (u *memUser) deleted() bool {
+	return !u.deletedAt.IsZero()
+}
+
+type memOrder struct {
+	Order
+	uploadedAt time.Time
+}
+
+type memBill struct {
+	Bill
+	processedAt time.Time
+}
+
+type memTransaction struct {
+	id          int64
+	kind        string
+	orderNumber string
+	reversesID  int64
+	reversedBy  int64
+	reason      string
+	createdAt   time.Time
+	userID      int
+	account     string
+	// amount is the user leg, counter leg is -amount
+	amount entities.Points
+}
+
+type memIdempotencyID struct {
+	key    string
+	userID int
+}
+
+type memRefreshToken struct {
+	RefreshToken
+	usedAt    time.Time
+	revokedAt time.Time
+}
+
+func NewMemoryStorage(i do.Injector) (*MemoryStorage, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	switch cfg.Account.DeletePolicy {
+	case "", DeletePolicyAnonymize, DeletePolicyCascade:
+	default:
+		return nil, errors.Wrapf(ErrUnknownDeletePolicy, "%q", cfg.Account.DeletePolicy)
+	}
+
+	return &MemoryStorage{
+		users:       make(map[int]*memUser),
+		logins:      make(map[string]int),
+		orders:      make(map[string]*memOrder),
+		bills:       make(map[string]*memBill),
+		idempotency: make(map[memIdempotencyID]IdempotencyKey),
+		refresh:     make(map[string]*memRefreshToken),
+		revoked:     make(map[string]time.Time),
+		cfg:         cfg,
+		log:         do.MustInvoke[*logger.Logger](i).WithField("component", "memory"),
+		hasher:      do.MustInvoke[*password.Manager](i),
+		tracer:      do.MustInvoke[*tracing.Provider](i).Tracer("github.com/wickedv43/yd-diploma/internal/storage"),
+	}, nil
+}
+
+// startSpan is a span of MemoryStorage method, traces look the same with
+// both storages
+func (m *MemoryStorage) startSpan(ctx context.Context, method string) (context.Context, trace.Span) {
+	return m.tracer.Start(ctx, "MemoryStorage."+method)
+}
+
+func (m *MemoryStorage) HealthCheck(context.Context) error {
+	return nil
+}
+
+func (m *MemoryStorage) Close() error {
+	return nil
+}
+
+func (m *MemoryStorage) Shutdown() error {
+	return m.Close()
+}
+
+// user returns active user, it must be called under lock
+func (m *MemoryStorage) user(id int) (*memUser, bool) {
+	u, ok := m.users[id]
+	if !ok || u.deleted() {
+		return nil, false
+	}
+
+	return u, true
+}
+
+func (u *memUser) toUser() User {
+	return User{
+		Login:   u.login,
+		ID:      u.id,
+		Role:    u.role,
+		Balance: u.balance,
+	}
+}
+
+func (m *MemoryStorage) RegisterUser(ctx context.Context, au AuthData) (User, error) {
+	_, span := m.startSpan(ctx, "RegisterUser")
+	defer span.End()
+
+	hash, err := m.hasher.Hash(au.Password)
+	if err != nil {
+		return User{}, errors.Wrap(err, "hash password")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.logins[au.Login]; ok {
+		return User{}, entities.ErrConflict
+	}
+
+	m.nextUserID++
+
+	u := &memUser{
+		id:       m.nextUserID,
+		login:    au.Login,
+		password: hash,
+		role:     RoleUser,
+	}
+
+	//no users on start, so admin is promoted on registration
+	if m.cfg.Admin.Login != "" && au.Login == m.cfg.Admin.Login {
+		u.role = RoleAdmin
+	}
+
+	m.users[u.id] = u
+	m.logins[u.login] = u.id
+
+	return u.toUser(), nil
+}
+
+// passwordHash is read under lock, slow verification runs without it
+func (m *MemoryStorage) passwordHash(id int) (string, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.user(id)
+	if !ok {
+		return "", false
+	}
+
+	return u.password, true
+}
+
+func (m *MemoryStorage) LoginUser(ctx context.Context, au AuthData) (User, error) {
+	_, span := m.startSpan(ctx, "LoginUser")
+	defer span.End()
+
+	m.mu.RLock()
+	id := m.logins[au.Login]
+	m.mu.RUnlock()
+
+	hash, ok := m.passwordHash(id)
+	if !ok {
+		//same timing as for existing user
+		m.hasher.VerifyDummy(au.Password)
+		return User{}, entities.ErrBadLogin
+	}
+
+	ok, rehash, err := m.hasher.Verify(au.Password, hash)
+	if err != nil {
+		return User{}, errors.Wrap(err, "verify password")
+	}
+	if !ok {
+		return User{}, entities.ErrBadLogin
+	}
+
+	//legacy plaintext or outdated hash
+	if rehash {
+		if next, err := m.hasher.Hash(au.Password); err == nil {
+			m.mu.Lock()
+			if u, ok := m.user(id); ok && u.password == hash {
+				u.password = next
+			}
+			m.mu.Unlock()
+		}
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.user(id)
+	if !ok {
+		return User{}, entities.ErrBadLogin
+	}
+
+	return u.toUser(), nil
+}
+
+func (m *MemoryStorage) Balance(ctx context.Context, userID int) (UserBalance, error) {
+	_, span := m.startSpan(ctx, "Balance")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.user(userID)
+	if !ok {
+		return UserBalance{}, entities.ErrNotFound
+	}
+
+	return u.balance, nil
+}
+
+func (m *MemoryStorage) verifyPassword(id int, pass string) error {
+	hash, ok := m.passwordHash(id)
+	if !ok {
+		return entities.ErrNotFound
+	}
+
+	ok, _, err := m.hasher.Verify(pass, hash)
+	if err != nil {
+		return errors.Wrap(err, "verify password")
+	}
+	if !ok {
+		return entities.ErrBadLogin
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) ChangePassword(ctx context.Context, userID int, current, next string) error {
+	_, span := m.startSpan(ctx, "ChangePassword")
+	defer span.End()
+
+	if err := m.verifyPassword(userID, current); err != nil {
+		return err
+	}
+
+	hash, err := m.hasher.Hash(next)
+	if err != nil {
+		return errors.Wrap(err, "hash password")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.user(userID)
+	if !ok {
+		return entities.ErrNotFound
+	}
+
+	u.password = hash
+
+	return nil
+}
+
+func (m *MemoryStorage) ChangeLogin(ctx context.Context, userID int, login string) error {
+	_, span := m.startSpan(ctx, "ChangeLogin")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.user(userID)
+	if !ok {
+		return entities.ErrNotFound
+	}
+
+	if owner, ok := m.logins[login]; ok && owner != userID {
+		return entities.ErrConflict
+	}
+
+	delete(m.logins, u.login)
+	u.login = login
+	m.logins[login] = userID
+
+	return nil
+}
+
+func (m *MemoryStorage) DeleteUser(ctx context.Context, userID int, pass string) error {
+	_, span := m.startSpan(ctx, "DeleteUser")
+	defer span.End()
+
+	if err := m.verifyPassword(userID, pass); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.user(userID)
+	if !ok {
+		return entities.ErrNotFound
+	}
+
+	switch m.cfg.Account.DeletePolicy {
+	case "", DeletePolicyAnonymize:
+		if err := m.anonymizeUser(u); err != nil {
+			return err
+		}
+	case DeletePolicyCascade:
+		m.cascadeUser(u)
+	default:
+		return errors.Wrapf(ErrUnknownDeletePolicy, "%q", m.cfg.Account.DeletePolicy)
+	}
+
+	m.log.Infof("user %d deleted, policy %s", userID, m.cfg.Account.DeletePolicy)
+
+	return nil
+}
+
+func (m *MemoryStorage) anonymizeUser(u *memUser) error {
+	login, err := anonymousLogin(int32(u.id))
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+
+	//write off
+	if u.balance.Current != 0 {
+		_ = m.post(posting{
+			userID:  int32(u.id),
+			kind:    LedgerAdjustment,
+			account: AccountAdjustments,
+			amount:  -u.balance.Current,
+			reason:  "account deleted",
+		})
+	}
+
+	delete(m.logins, u.login)
+	u.login = login
+	u.password = ""
+	u.deletedAt = now
+	m.logins[u.login] = u.id
+
+	for _, t := range m.refresh {
+		if t.UserID == u.id && t.revokedAt.IsZero() {
+			t.revokedAt = now
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) cascadeUser(u *memUser) {
+	ledger := m.ledger[:0]
+	for _, t := range m.ledger {
+		if t.userID != u.id {
+			ledger = append(ledger, t)
+		}
+	}
+	m.ledger = ledger
+
+	for number, o := range m.orders {
+		if o.UserID == u.id {
+			delete(m.orders, number)
+		}
+	}
+
+	for number, b := range m.bills {
+		if b.UserID == u.id {
+			delete(m.bills, number)
+		}
+	}
+
+	for id := range m.idempotency {
+		if id.userID == u.id {
+			delete(m.idempotency, id)
+		}
+	}
+
+	for hash, t := range m.refresh {
+		if t.UserID == u.id {
+			delete(m.refresh, hash)
+		}
+	}
+
+	//audit_log.actor_id is set null
+	for i := range m.audit {
+		if m.audit[i].ActorID == u.id {
+			m.audit[i].ActorID = 0
+		}
+	}
+
+	delete(m.logins, u.login)
+	delete(m.users, u.id)
+}
+
+func (m *MemoryStorage) CreateOrder(ctx context.Context, order Order) error {
+	_, span := m.startSpan(ctx, "CreateOrder")
+	defer span.End()
+
+	uploadedAt, err := time.Parse(time.RFC3339, order.UploadedAt)
+	if err != nil {
+		return errors.Wrap(err, "parse uploaded at")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if existing, ok := m.orders[order.Number]; ok {
+		if existing.UserID == order.UserID {
+			return entities.ErrAlreadyExists
+		}
+		return entities.ErrConflict
+	}
+
+	if _, ok := m.users[order.UserID]; !ok {
+		return errors.Wrapf(entities.ErrNotFound, "user %d", order.UserID)
+	}
+
+	order.Status = StatusNew
+
+	m.orders[order.Number] = &memOrder{
+		Order:      order,
+		uploadedAt: uploadedAt,
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) UnprocessedOrders(ctx context.Context, limit int) ([]Order, error) {
+	_, span := m.startSpan(ctx, "UnprocessedOrders")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var pending []*memOrder
+	for _, o := range m.orders {
+		if o.Status != StatusNew && o.Status != StatusProcessing {
+			continue
+		}
+		if _, ok := m.user(o.UserID); !ok {
+			continue
+		}
+		pending = append(pending, o)
+	}
+
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].uploadedAt.Before(pending[j].uploadedAt)
+	})
+
+	var orders []Order
+	for _, o := range pending {
+		if len(orders) == limit {
+			break
+		}
+		orders = append(orders, o.Order)
+	}
+
+	return orders, nil
+}
+
+func (m *MemoryStorage) CountUnprocessedOrders(ctx context.Context) (int64, error) {
+	_, span := m.startSpan(ctx, "CountUnprocessedOrders")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var n int64
+	for _, o := range m.orders {
+		if o.Status != StatusNew && o.Status != StatusProcessing {
+			continue
+		}
+		if _, ok := m.user(o.UserID); !ok {
+			continue
+		}
+		n++
+	}
+
+	return n, nil
+}
+
+func (m *MemoryStorage) UpdateOrder(ctx context.Context, order Order) error {
+	_, span := m.startSpan(ctx, "UpdateOrder")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.orders[order.Number]
+	if !ok {
+		return entities.ErrNotFound
+	}
+
+	//final statuses never change
+	if current.Status == StatusInvalid || current.Status == StatusProcessed {
+		return nil
+	}
+
+	//credit user
+	if order.Status == StatusProcessed && order.Accrual > 0 {
+		err := m.post(posting{
+			userID:      int32(current.UserID),
+			kind:        LedgerAccrual,
+			account:     AccountAccruals,
+			amount:      order.Accrual,
+			orderNumber: order.Number,
+		})
+		if err != nil {
+			return errors.Wrap(err, "post accrual")
+		}
+	}
+
+	current.Status = order.Status
+	current.Accrual = order.Accrual
+
+	return nil
+}
+
+func (m *MemoryStorage) ListOrders(ctx context.Context, q ListQuery) (OrderPage, error) {
+	_, span := m.startSpan(ctx, "ListOrders")
+	defer span.End()
+
+	after, err := parseCursor(q.Cursor)
+	if err != nil {
+		return OrderPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var orders []*memOrder
+	for _, o := range m.orders {
+		if o.UserID != q.UserID || !q.inRange(o.uploadedAt) {
+			continue
+		}
+		if len(q.Statuses) > 0 && !slices.Contains(q.Statuses, o.Status) {
+			continue
+		}
+		if !after.after(o.uploadedAt, o.Number, q.Asc) {
+			continue
+		}
+
+		orders = append(orders, o)
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		if q.Asc {
+			return rowLess(orders[i].uploadedAt, orders[i].Number, orders[j].uploadedAt, orders[j].Number)
+		}
+		return rowLess(orders[j].uploadedAt, orders[j].Number, orders[i].uploadedAt, orders[i].Number)
+	})
+
+	var page OrderPage
+
+	if size := q.pageSize(); len(orders) > size {
+		orders = orders[:size]
+		last := orders[size-1]
+		page.NextCursor = cursor{at: last.uploadedAt, number: last.Number}.String()
+	}
+
+	for _, o := range orders {
+		page.Orders = append(page.Orders, o.Order)
+	}
+
+	return page, nil
+}
+
+func (m *MemoryStorage) ProcessPayment(ctx context.Context, bill Bill) error {
+	_, span := m.startSpan(ctx, "ProcessPayment")
+	defer span.End()
+
+	if !util.LuhnCheck(bill.Order) {
+		return entities.ErrBadOrder
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	//order number can be used for withdrawal only once
+	if _, ok := m.bills[bill.Order]; ok {
+		return entities.ErrBadOrder
+	}
+
+	err := m.post(posting{
+		userID:      int32(bill.UserID),
+		kind:        LedgerWithdrawal,
+		account:     AccountWithdrawals,
+		amount:      -bill.Sum,
+		withdrawn:   bill.Sum,
+		orderNumber: bill.Order,
+	})
+	if err != nil {
+		return errors.Wrap(err, "post withdrawal")
+	}
+
+	now := time.Now()
+	bill.ProcessedAt = now.Format(time.RFC3339)
+
+	m.bills[bill.Order] = &memBill{
+		Bill:        bill,
+		processedAt: now,
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) ListWithdrawals(ctx context.Context, q ListQuery) (BillPage, error) {
+	_, span := m.startSpan(ctx, "ListWithdrawals")
+	defer span.End()
+
+	after, err := parseCursor(q.Cursor)
+	if err != nil {
+		return BillPage{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var bills []*memBill
+	for _, b := range m.bills {
+		if b.UserID != q.UserID || !q.inRange(b.processedAt) {
+			continue
+		}
+		if !after.after(b.processedAt, b.Order, q.Asc) {
+			continue
+		}
+
+		bills = append(bills, b)
+	}
+
+	sort.Slice(bills, func(i, j int) bool {
+		if q.Asc {
+			return rowLess(bills[i].processedAt, bills[i].Order, bills[j].processedAt, bills[j].Order)
+		}
+		return rowLess(bills[j].processedAt, bills[j].Order, bills[i].processedAt, bills[i].Order)
+	})
+
+	var page BillPage
+
+	if size := q.pageSize(); len(bills) > size {
+		bills = bills[:size]
+		last := bills[size-1]
+		page.NextCursor = cursor{at: last.processedAt, number: last.Order}.String()
+	}
+
+	for _, b := range bills {
+		page.Bills = append(page.Bills, b.Bill)
+	}
+
+	return page, nil
+}
+
+// post is the in-memory twin of post, it must be called under lock and
+// changes nothing on error
+func (m *MemoryStorage) post(p posting) error {
+	u, ok := m.users[int(p.userID)]
+	if !ok {
+		return errors.Wrapf(entities.ErrNotFound, "user %d", p.userID)
+	}
+
+	//balance can't go below zero
+	if u.balance.Current+p.amount < 0 {
+		return entities.ErrHaveEnoughMoney
+	}
+
+	if p.kind == LedgerAccrual {
+		for _, t := range m.ledger {
+			if t.kind == LedgerAccrual && t.orderNumber == p.orderNumber {
+				return errors.Wrapf(entities.ErrConflict, "order %s already credited", p.orderNumber)
+			}
+		}
+	}
+
+	m.nextTransactionID++
+
+	m.ledger = append(m.ledger, &memTransaction{
+		id:          m.nextTransactionID,
+		kind:        p.kind,
+		orderNumber: p.orderNumber,
+		reversesID:  p.reversesID,
+		reason:      p.reason,
+		createdAt:   time.Now(),
+		userID:      int(p.userID),
+		account:     p.account,
+		amount:      p.amount,
+	})
+
+	u.balance.Current += p.amount
+	u.balance.Withdrawn += p.withdrawn
+
+	return nil
+}
+
+func (m *MemoryStorage) Ledger(ctx context.Context, userID int) ([]LedgerEntry, error) {
+	_, span := m.startSpan(ctx, "Ledger")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []LedgerEntry
+
+	//newest first
+	for i := len(m.ledger) - 1; i >= 0; i-- {
+		t := m.ledger[i]
+		if t.userID != userID {
+			continue
+		}
+
+		entries = append(entries, LedgerEntry{
+			ID:          t.id,
+			Kind:        t.kind,
+			Amount:      t.amount,
+			OrderNumber: t.orderNumber,
+			ReversesID:  t.reversesID,
+			Reason:      t.reason,
+			CreatedAt:   t.createdAt.Format(time.RFC3339),
+		})
+	}
+
+	return entries, nil
+}
+
+func (m *MemoryStorage) AdjustBalance(ctx context.Context, userID int, amount entities.Points, reason string, audit AuditRecord) error {
+	_, span := m.startSpan(ctx, "AdjustBalance")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	err := m.post(posting{
+		userID:  int32(userID),
+		kind:    LedgerAdjustment,
+		account: AccountAdjustments,
+		amount:  amount,
+		reason:  reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	m.writeAudit(audit)
+
+	return nil
+}
+
+func (m *MemoryStorage) ReverseTransaction(ctx context.Context, id int64, reason string) error {
+	_, span := m.startSpan(ctx, "ReverseTransaction")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var original *memTransaction
+	for _, t := range m.ledger {
+		if t.id == id {
+			original = t
+			break
+		}
+	}
+	if original == nil {
+		return entities.ErrNotFound
+	}
+
+	if original.kind == LedgerReversal {
+		return errors.Wrap(entities.ErrConflict, "reversal can't be reversed")
+	}
+	if original.reversedBy != 0 {
+		return errors.Wrap(entities.ErrConflict, "transaction already reversed")
+	}
+
+	p := posting{
+		userID:      int32(original.userID),
+		kind:        LedgerReversal,
+		account:     original.account,
+		amount:      -original.amount,
+		orderNumber: original.orderNumber,
+		reversesID:  original.id,
+		reason:      reason,
+	}
+
+	if original.kind == LedgerWithdrawal {
+		p.withdrawn = -p.amount
+	}
+
+	if err := m.post(p); err != nil {
+		return err
+	}
+
+	original.reversedBy = m.nextTransactionID
+
+	return nil
+}
+
+func (m *MemoryStorage) CheckLedger(ctx context.Context) ([]LedgerMismatch, error) {
+	_, span := m.startSpan(ctx, "CheckLedger")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	kinds := make(map[int64]string, len(m.ledger))
+	derived := make(map[int]UserBalance)
+
+	for _, t := range m.ledger {
+		kinds[t.id] = t.kind
+
+		b := derived[t.userID]
+		b.Current += t.amount
+		if t.kind == LedgerWithdrawal || kinds[t.reversesID] == LedgerWithdrawal {
+			b.Withdrawn -= t.amount
+		}
+		derived[t.userID] = b
+	}
+
+	var mismatches []LedgerMismatch
+
+	for _, u := range m.users {
+		if derived[u.id] != u.balance {
+			mismatches = append(mismatches, LedgerMismatch{
+				UserID:  u.id,
+				Stored:  u.balance,
+				Derived: derived[u.id],
+			})
+		}
+	}
+
+	sort.Slice(mismatches, func(i, j int) bool {
+		return mismatches[i].UserID < mismatches[j].UserID
+	})
+
+	//legs are stored as one amount, so transactions always sum to zero
+	return mismatches, nil
+}
+
+func (m *MemoryStorage) UserRole(ctx context.Context, userID int) (string, error) {
+	_, span := m.startSpan(ctx, "UserRole")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	u, ok := m.user(userID)
+	if !ok {
+		return "", entities.ErrNotFound
+	}
+
+	return u.role, nil
+}
+
+func (m *MemoryStorage) SetUserRole(ctx context.Context, userID int, role string) error {
+	_, span := m.startSpan(ctx, "SetUserRole")
+	defer span.End()
+
+	if RoleRank(role) == 0 {
+		return errors.Wrapf(ErrUnknownRole, "%q", role)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.user(userID)
+	if !ok {
+		return entities.ErrNotFound
+	}
+
+	u.role = role
+
+	return nil
+}
+
+func (m *MemoryStorage) UserByLogin(ctx context.Context, login string) (User, error) {
+	_, span := m.startSpan(ctx, "UserByLogin")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	id, ok := m.logins[login]
+	if !ok {
+		return User{}, entities.ErrNotFound
+	}
+
+	u, ok := m.user(id)
+	if !ok {
+		return User{}, entities.ErrNotFound
+	}
+
+	return u.toUser(), nil
+}
+
+func (m *MemoryStorage) Order(ctx context.Context, number string) (Order, error) {
+	_, span := m.startSpan(ctx, "Order")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	o, ok := m.orders[number]
+	if !ok {
+		return Order{}, entities.ErrNotFound
+	}
+
+	return o.Order, nil
+}
+
+func (m *MemoryStorage) WriteAudit(ctx context.Context, record AuditRecord) error {
+	_, span := m.startSpan(ctx, "WriteAudit")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.writeAudit(record)
+
+	return nil
+}
+
+func (m *MemoryStorage) writeAudit(record AuditRecord) {
+	if len(record.Details) == 0 {
+		record.Details = json.RawMessage("{}")
+	}
+
+	m.nextAuditID++
+	record.ID = m.nextAuditID
+	record.CreatedAt = time.Now().Format(time.RFC3339)
+
+	m.audit = append(m.audit, record)
+}
+
+func (m *MemoryStorage) AuditLog(ctx context.Context, targetUserID int, limit int) ([]AuditRecord, error) {
+	_, span := m.startSpan(ctx, "AuditLog")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var records []AuditRecord
+
+	for i := len(m.audit) - 1; i >= 0 && len(records) < limit; i-- {
+		r := m.audit[i]
+		if targetUserID != 0 && r.TargetUserID != targetUserID {
+			continue
+		}
+		records = append(records, r)
+	}
+
+	return records, nil
+}
+
+func (m *MemoryStorage) ReserveIdempotencyKey(ctx context.Context, key IdempotencyKey) (IdempotencyKey, error) {
+	_, span := m.startSpan(ctx, "ReserveIdempotencyKey")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := memIdempotencyID{key: key.Key, userID: key.UserID}
+
+	//expired keys are reused
+	if stored, ok := m.idempotency[id]; ok && !stored.ExpiresAt.Before(time.Now()) {
+		return stored, entities.ErrAlreadyExists
+	}
+
+	m.idempotency[id] = IdempotencyKey{
+		Key:         key.Key,
+		UserID:      key.UserID,
+		RequestHash: key.RequestHash,
+		ExpiresAt:   key.ExpiresAt,
+	}
+
+	return key, nil
+}
+
+func (m *MemoryStorage) SaveIdempotencyResponse(ctx context.Context, key IdempotencyKey) error {
+	_, span := m.startSpan(ctx, "SaveIdempotencyResponse")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := memIdempotencyID{key: key.Key, userID: key.UserID}
+
+	stored, ok := m.idempotency[id]
+	if !ok {
+		return nil
+	}
+
+	stored.StatusCode = key.StatusCode
+	stored.ContentType = key.ContentType
+	stored.Response = key.Response
+	m.idempotency[id] = stored
+
+	return nil
+}
+
+func (m *MemoryStorage) DeleteIdempotencyKey(ctx context.Context, key string, userID int) error {
+	_, span := m.startSpan(ctx, "DeleteIdempotencyKey")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.idempotency, memIdempotencyID{key: key, userID: userID})
+
+	return nil
+}
+
+func (m *MemoryStorage) DeleteExpiredIdempotencyKeys(ctx context.Context, before time.Time) (int64, error) {
+	_, span := m.startSpan(ctx, "DeleteExpiredIdempotencyKeys")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for id, key := range m.idempotency {
+		if key.ExpiresAt.Before(before) {
+			delete(m.idempotency, id)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+func (m *MemoryStorage) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	_, span := m.startSpan(ctx, "CreateRefreshToken")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[token.UserID]; !ok {
+		return errors.Wrapf(entities.ErrNotFound, "user %d", token.UserID)
+	}
+
+	if _, ok := m.refresh[token.Hash]; ok {
+		return errors.Wrap(entities.ErrConflict, "refresh token exists")
+	}
+
+	m.refresh[token.Hash] = &memRefreshToken{RefreshToken: token}
+
+	return nil
+}
+
+func (m *MemoryStorage) RotateRefreshToken(ctx context.Context, hash string, next RefreshToken) (RefreshToken, error) {
+	_, span := m.startSpan(ctx, "RotateRefreshToken")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	current, ok := m.refresh[hash]
+	if !ok {
+		return RefreshToken{}, entities.ErrNotFound
+	}
+
+	now := time.Now()
+
+	//replay
+	if !current.usedAt.IsZero() || !current.revokedAt.IsZero() {
+		m.revokeFamily(current.FamilyID, now)
+		m.log.Warnf("refresh token reused, family %s of user %d revoked", current.FamilyID, current.UserID)
+
+		return RefreshToken{}, entities.ErrTokenReused
+	}
+
+	if current.ExpiresAt.Before(now) {
+		return RefreshToken{}, entities.ErrNotFound
+	}
+
+	if _, ok = m.refresh[next.Hash]; ok {
+		return RefreshToken{}, errors.Wrap(entities.ErrConflict, "refresh token exists")
+	}
+
+	current.usedAt = now
+
+	next.FamilyID = current.FamilyID
+	next.UserID = current.UserID
+	m.refresh[next.Hash] = &memRefreshToken{RefreshToken: next}
+
+	return next, nil
+}
+
+func (m *MemoryStorage) revokeFamily(familyID string, now time.Time) {
+	for _, t := range m.refresh {
+		if t.FamilyID == familyID && t.revokedAt.IsZero() {
+			t.revokedAt = now
+		}
+	}
+}
+
+func (m *MemoryStorage) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	_, span := m.startSpan(ctx, "RevokeRefreshFamily")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.revokeFamily(familyID, time.Now())
+
+	return nil
+}
+
+func (m *MemoryStorage) RevokeUserSessions(ctx context.Context, userID int, exceptFamilyID string) error {
+	_, span := m.startSpan(ctx, "RevokeUserSessions")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, t := range m.refresh {
+		if t.UserID == userID && t.FamilyID != exceptFamilyID && t.revokedAt.IsZero() {
+			t.revokedAt = now
+		}
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	_, span := m.startSpan(ctx, "RevokeAccessToken")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.revoked[jti]; !ok {
+		m.revoked[jti] = expiresAt
+	}
+
+	return nil
+}
+
+func (m *MemoryStorage) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	_, span := m.startSpan(ctx, "IsAccessTokenRevoked")
+	defer span.End()
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	_, ok := m.revoked[jti]
+
+	return ok, nil
+}
+
+func (m *MemoryStorage) DeleteExpiredTokens(ctx context.Context, before time.Time) (int64, error) {
+	_, span := m.startSpan(ctx, "DeleteExpiredTokens")
+	defer span.End()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+
+	for hash, t := range m.refresh {
+		if t.ExpiresAt.Before(before) {
+			delete(m.refresh, hash)
+			deleted++
+		}
+	}
+
+	for jti, expiresAt := range m.revoked {
+		if expiresAt.Before(before) {
+			delete(m.revoked, jti)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
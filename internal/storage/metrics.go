@@ -0,0 +1,20 @@
+package storage
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/wickedv43/yd-diploma/internal/metrics"
+)
+
+func newQueryDuration(r *metrics.Registry) *prometheus.HistogramVec {
+	h := prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metrics.Namespace,
+		Subsystem: "storage",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of storage queries by sqlc query name.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"query"})
+
+	r.MustRegister(h)
+
+	return h
+}
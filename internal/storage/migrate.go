@@ -0,0 +1,408 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+)
+
+//go:embed migrations/*.sql
+var migrationsFS embed.FS
+
+// migrationLockID is a pg_advisory_lock key, concurrent starts wait for
+// the first one to finish migrating
+const migrationLockID = 7264012
+
+var (
+	ErrBadMigration  = errors.New("bad migration")
+	ErrUnknownSchema = errors.New("existing schema has no migration history and doesn't match 0001_init")
+)
+
+// initSchemaQuery checks that existing tables are exactly what 0001_init
+// creates. Builds before migrations kept editing schema.sql: money columns
+// became NUMERIC first, then tables and columns of later migrations were added.
+const initSchemaQuery = `SELECT COALESCE((
+    SELECT data_type = 'integer'
+    FROM information_schema.columns
+    WHERE table_schema = current_schema() AND table_name = 'users' AND column_name = 'balance_current'
+  ), false)
+  AND NOT EXISTS (
+    SELECT 1
+    FROM information_schema.tables
+    WHERE table_schema = current_schema()
+      AND table_name IN ('ledger_transactions', 'ledger_entries', 'idempotency_keys',
+        'refresh_tokens', 'revoked_tokens', 'login_attempts', 'audit_log')
+  )
+  AND NOT EXISTS (
+    SELECT 1
+    FROM information_schema.columns
+    WHERE table_schema = current_schema() AND table_name = 'users' AND column_name IN ('deleted_at', 'role')
+  )`
+
+// Migration is a pair of NNNN_name.up.sql and NNNN_name.down.sql files
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+	Applied   bool
+}
+
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	log        *logrus.Entry
+}
+
+// NewMigrator opens its own connection, so migrate subcommand doesn't
+// start the storage
+func NewMigrator(i do.Injector) (*Migrator, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+	log := do.MustInvoke[*logger.Logger](i).WithField("component", "migrator")
+
+	pgDB, err := sql.Open("postgres", cfg.Database.DSN)
+	if err != nil {
+		return nil, errors.Wrap(err, "connect to postgres")
+	}
+
+	return newMigrator(pgDB, log)
+}
+
+func newMigrator(pgDB *sql.DB, log *logrus.Entry) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		return nil, errors.Wrap(err, "load migrations")
+	}
+
+	return &Migrator{
+		db:         pgDB,
+		migrations: migrations,
+		log:        log,
+	}, nil
+}
+
+func (m *Migrator) Close() error {
+	return m.db.Close()
+}
+
+func loadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "migrations/*.sql")
+	if err != nil {
+		return nil, errors.Wrap(err, "list migrations")
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, file := range files {
+		name := path.Base(file)
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, errors.Wrapf(ErrBadMigration, "%s: no direction", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionPart, title, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, errors.Wrapf(ErrBadMigration, "%s: no version", name)
+		}
+
+		version, err := strconv.Atoi(versionPart)
+		if err != nil || version <= 0 {
+			return nil, errors.Wrapf(ErrBadMigration, "%s: bad version", name)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "read %s", name)
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: title}
+			byVersion[version] = mig
+		}
+		if mig.Name != title {
+			return nil, errors.Wrapf(ErrBadMigration, "version %d has two names", version)
+		}
+
+		if direction == "up" {
+			mig.Up = string(data)
+		} else {
+			mig.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, errors.Wrapf(ErrBadMigration, "version %d has no up migration", mig.Version)
+		}
+		migrations = append(migrations, *mig)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// Latest is the version the code expects
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the last applied migration
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	var version sql.NullInt32
+
+	err := m.db.QueryRowContext(ctx, `SELECT MAX(version) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, errors.Wrap(err, "get schema version")
+	}
+
+	return int(version.Int32), nil
+}
+
+// withLock runs fn on a single connection holding the migration lock
+func (m *Migrator) withLock(ctx context.Context, fn func(*sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return errors.Wrap(err, "get connection")
+	}
+	defer conn.Close()
+
+	if _, err = conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return errors.Wrap(err, "lock migrations")
+	}
+	defer func() {
+		//lock is released with the session anyway
+		_, err := conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID)
+		if err != nil {
+			m.log.Error(errors.Wrap(err, "unlock migrations"))
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version INTEGER PRIMARY KEY,
+  name VARCHAR(255) NOT NULL,
+  applied_at TIMESTAMPTZ NOT NULL DEFAULT (now())
+)`)
+	if err != nil {
+		return errors.Wrap(err, "create schema_migrations")
+	}
+
+	return fn(conn)
+}
+
+func applied(ctx context.Context, conn *sql.Conn) (map[int]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, errors.Wrap(err, "get applied migrations")
+	}
+	defer rows.Close()
+
+	versions := make(map[int]time.Time)
+	for rows.Next() {
+		var (
+			version   int
+			appliedAt time.Time
+		)
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, errors.Wrap(err, "scan applied migration")
+		}
+		versions[version] = appliedAt
+	}
+
+	return versions, rows.Err()
+}
+
+// baseline marks the initial migration applied for databases created by
+// the old schema.sql, which have tables but no schema_migrations rows.
+// Schema of any other shape is refused, later migrations would fail on it.
+func (m *Migrator) baseline(ctx context.Context, conn *sql.Conn, versions map[int]time.Time) error {
+	if len(versions) > 0 || len(m.migrations) == 0 {
+		return nil
+	}
+
+	var exists bool
+	err := conn.QueryRowContext(ctx, `SELECT to_regclass('users') IS NOT NULL`).Scan(&exists)
+	if err != nil {
+		return errors.Wrap(err, "check users table")
+	}
+	if !exists {
+		return nil
+	}
+
+	var initial bool
+	if err = conn.QueryRowContext(ctx, initSchemaQuery).Scan(&initial); err != nil {
+		return errors.Wrap(err, "check existing schema")
+	}
+	if !initial {
+		return errors.Wrap(ErrUnknownSchema, "migrate it by hand and fill schema_migrations")
+	}
+
+	first := m.migrations[0]
+	_, err = conn.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, first.Version, first.Name)
+	if err != nil {
+		return errors.Wrap(err, "baseline schema")
+	}
+
+	versions[first.Version] = time.Now()
+	m.log.Warnf("existing schema found, marked as version %d", first.Version)
+
+	return nil
+}
+
+// Up applies every pending migration, each one in its own transaction
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		if err = m.baseline(ctx, conn, versions); err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if _, ok := versions[mig.Version]; ok {
+				continue
+			}
+
+			err = m.apply(ctx, conn, mig.Up, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, mig.Version, mig.Name)
+				return err
+			})
+			if err != nil {
+				return errors.Wrapf(err, "migration %04d_%s up", mig.Version, mig.Name)
+			}
+
+			m.log.Infof("migration %04d_%s applied", mig.Version, mig.Name)
+		}
+
+		return nil
+	})
+}
+
+// Down rolls back the last steps applied migrations
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && steps > 0; i-- {
+			mig := m.migrations[i]
+			if _, ok := versions[mig.Version]; !ok {
+				continue
+			}
+
+			if mig.Down == "" {
+				return errors.Wrapf(ErrBadMigration, "%04d_%s has no down migration", mig.Version, mig.Name)
+			}
+
+			err = m.apply(ctx, conn, mig.Down, func(tx *sql.Tx) error {
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+				return err
+			})
+			if err != nil {
+				return errors.Wrapf(err, "migration %04d_%s down", mig.Version, mig.Name)
+			}
+
+			m.log.Infof("migration %04d_%s rolled back", mig.Version, mig.Name)
+			steps--
+		}
+
+		return nil
+	})
+}
+
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, query string, record func(*sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, query); err != nil {
+		return errors.Wrap(err, "exec")
+	}
+
+	if err = record(tx); err != nil {
+		return errors.Wrap(err, "record version")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit transaction")
+	}
+
+	return nil
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		versions, err := applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			appliedAt, ok := versions[mig.Version]
+			statuses = append(statuses, MigrationStatus{
+				Version:   mig.Version,
+				Name:      mig.Name,
+				AppliedAt: appliedAt,
+				Applied:   ok,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return statuses, nil
+}
+
+func (s MigrationStatus) String() string {
+	if !s.Applied {
+		return fmt.Sprintf("%04d_%s\tpending", s.Version, s.Name)
+	}
+
+	return fmt.Sprintf("%04d_%s\tapplied %s", s.Version, s.Name, s.AppliedAt.Format(time.RFC3339))
+}
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS "bills";
+
+DROP TABLE IF EXISTS "orders";
+
+DROP TABLE IF EXISTS "users";
@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS "ledger_entries";
+
+DROP TABLE IF EXISTS "ledger_transactions";
+
+ALTER TABLE "bills" DROP CONSTRAINT IF EXISTS "bills_order_number_key";
+
+ALTER TABLE "bills" ALTER COLUMN "sum" TYPE INTEGER;
+
+ALTER TABLE "orders" ALTER COLUMN "accrual" TYPE INTEGER;
+
+ALTER TABLE "users" ALTER COLUMN "balance_current" TYPE INTEGER,
+  ALTER COLUMN "balance_current" SET DEFAULT 500,
+  ALTER COLUMN "balance_withdrawn" TYPE INTEGER,
+  ALTER COLUMN "balance_withdrawn" SET DEFAULT 500;
@@ -0,0 +1,70 @@
+ALTER TABLE "users" ALTER COLUMN "balance_current" TYPE NUMERIC(14, 2),
+  ALTER COLUMN "balance_current" SET DEFAULT 0,
+  ALTER COLUMN "balance_withdrawn" TYPE NUMERIC(14, 2),
+  ALTER COLUMN "balance_withdrawn" SET DEFAULT 0;
+
+ALTER TABLE "orders" ALTER COLUMN "accrual" TYPE NUMERIC(14, 2);
+
+ALTER TABLE "bills" ALTER COLUMN "sum" TYPE NUMERIC(14, 2);
+
+-- withdrawal order numbers are not uploaded orders
+ALTER TABLE "bills" DROP CONSTRAINT IF EXISTS "bills_order_number_fkey";
+
+ALTER TABLE "bills" ADD UNIQUE ("order_number");
+
+CREATE TABLE "ledger_transactions" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "kind" VARCHAR(20) NOT NULL CHECK ("kind" IN ('accrual', 'withdrawal', 'adjustment', 'reversal')),
+  "order_number" VARCHAR(255),
+  "reverses_id" BIGINT UNIQUE,
+  "reason" TEXT NOT NULL DEFAULT '',
+  "created_at" TIMESTAMPTZ NOT NULL DEFAULT (now())
+);
+
+CREATE TABLE "ledger_entries" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "transaction_id" BIGINT NOT NULL,
+  "account" VARCHAR(50) NOT NULL,
+  "user_id" INTEGER,
+  "amount" NUMERIC(14, 2) NOT NULL
+);
+
+CREATE UNIQUE INDEX ON "ledger_transactions" ("order_number") WHERE "kind" = 'accrual';
+
+CREATE INDEX ON "ledger_entries" ("user_id");
+
+CREATE INDEX ON "ledger_entries" ("transaction_id");
+
+ALTER TABLE "ledger_transactions" ADD FOREIGN KEY ("reverses_id") REFERENCES "ledger_transactions" ("id");
+
+ALTER TABLE "ledger_entries" ADD FOREIGN KEY ("transaction_id") REFERENCES "ledger_transactions" ("id");
+
+ALTER TABLE "ledger_entries" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id");
+
+-- opening balances, so existing users pass the ledger check:
+-- adjustment brings in everything ever owned, withdrawal takes out what was spent
+DO $$
+DECLARE
+  u RECORD;
+  tx BIGINT;
+BEGIN
+  FOR u IN SELECT "id", "balance_current", "balance_withdrawn" FROM "users"
+    WHERE "balance_current" <> 0 OR "balance_withdrawn" <> 0
+  LOOP
+    INSERT INTO "ledger_transactions" ("kind", "reason")
+    VALUES ('adjustment', 'opening balance') RETURNING "id" INTO tx;
+
+    INSERT INTO "ledger_entries" ("transaction_id", "account", "user_id", "amount")
+    VALUES (tx, 'user', u."id", u."balance_current" + u."balance_withdrawn"),
+           (tx, 'adjustments', NULL, -(u."balance_current" + u."balance_withdrawn"));
+
+    IF u."balance_withdrawn" <> 0 THEN
+      INSERT INTO "ledger_transactions" ("kind", "reason")
+      VALUES ('withdrawal', 'opening balance') RETURNING "id" INTO tx;
+
+      INSERT INTO "ledger_entries" ("transaction_id", "account", "user_id", "amount")
+      VALUES (tx, 'user', u."id", -u."balance_withdrawn"),
+             (tx, 'withdrawals', NULL, u."balance_withdrawn");
+    END IF;
+  END LOOP;
+END $$;
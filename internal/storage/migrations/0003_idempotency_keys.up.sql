@@ -0,0 +1,15 @@
+CREATE TABLE "idempotency_keys" (
+  "key" VARCHAR(255) NOT NULL,
+  "user_id" INTEGER NOT NULL,
+  "request_hash" VARCHAR(64) NOT NULL,
+  "status_code" INTEGER NOT NULL DEFAULT 0,
+  "content_type" VARCHAR(255) NOT NULL DEFAULT '',
+  "response_body" BYTEA,
+  "created_at" TIMESTAMPTZ NOT NULL DEFAULT (now()),
+  "expires_at" TIMESTAMPTZ NOT NULL,
+  PRIMARY KEY ("key", "user_id")
+);
+
+CREATE INDEX ON "idempotency_keys" ("expires_at");
+
+ALTER TABLE "idempotency_keys" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
@@ -0,0 +1,21 @@
+CREATE TABLE "refresh_tokens" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "token_hash" VARCHAR(64) UNIQUE NOT NULL,
+  "family_id" VARCHAR(64) NOT NULL,
+  "user_id" INTEGER NOT NULL,
+  "expires_at" TIMESTAMPTZ NOT NULL,
+  "used_at" TIMESTAMPTZ,
+  "revoked_at" TIMESTAMPTZ,
+  "created_at" TIMESTAMPTZ NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX ON "refresh_tokens" ("family_id");
+
+CREATE INDEX ON "refresh_tokens" ("user_id");
+
+CREATE TABLE "revoked_tokens" (
+  "jti" VARCHAR(64) PRIMARY KEY,
+  "expires_at" TIMESTAMPTZ NOT NULL
+);
+
+ALTER TABLE "refresh_tokens" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
@@ -0,0 +1,6 @@
+CREATE TABLE "login_attempts" (
+  "key" VARCHAR(320) PRIMARY KEY,
+  "failures" INTEGER NOT NULL DEFAULT 0,
+  "last_failure_at" TIMESTAMPTZ NOT NULL,
+  "locked_until" TIMESTAMPTZ
+);
@@ -0,0 +1,9 @@
+ALTER TABLE "bills" DROP CONSTRAINT IF EXISTS "bills_user_id_fkey";
+
+ALTER TABLE "bills" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id");
+
+ALTER TABLE "orders" DROP CONSTRAINT IF EXISTS "orders_user_id_fkey";
+
+ALTER TABLE "orders" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id");
+
+ALTER TABLE "users" DROP COLUMN IF EXISTS "deleted_at";
@@ -0,0 +1,9 @@
+ALTER TABLE "users" ADD COLUMN "deleted_at" TIMESTAMPTZ;
+
+ALTER TABLE "orders" DROP CONSTRAINT IF EXISTS "orders_user_id_fkey";
+
+ALTER TABLE "orders" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
+
+ALTER TABLE "bills" DROP CONSTRAINT IF EXISTS "bills_user_id_fkey";
+
+ALTER TABLE "bills" ADD FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE CASCADE;
@@ -0,0 +1,14 @@
+ALTER TABLE "users" ADD COLUMN "role" VARCHAR(20) NOT NULL DEFAULT 'user' CHECK ("role" IN ('user', 'support', 'admin'));
+
+CREATE TABLE "audit_log" (
+  "id" BIGSERIAL PRIMARY KEY,
+  "actor_id" INTEGER NOT NULL,
+  "action" VARCHAR(50) NOT NULL,
+  "target_user_id" INTEGER,
+  "details" JSONB NOT NULL DEFAULT '{}',
+  "created_at" TIMESTAMPTZ NOT NULL DEFAULT (now())
+);
+
+CREATE INDEX ON "audit_log" ("target_user_id");
+
+ALTER TABLE "audit_log" ADD FOREIGN KEY ("actor_id") REFERENCES "users" ("id");
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS "bills_user_processed_idx";
+
+DROP INDEX IF EXISTS "orders_user_uploaded_idx";
@@ -0,0 +1,3 @@
+CREATE INDEX "orders_user_uploaded_idx" ON "orders" ("user_id", "uploaded_at", "number");
+
+CREATE INDEX "bills_user_processed_idx" ON "bills" ("user_id", "processed_at", "order_number");
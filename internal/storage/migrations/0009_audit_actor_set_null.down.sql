@@ -0,0 +1,7 @@
+ALTER TABLE "audit_log" DROP CONSTRAINT "audit_log_actor_id_fkey";
+
+DELETE FROM "audit_log" WHERE "actor_id" IS NULL;
+
+ALTER TABLE "audit_log" ALTER COLUMN "actor_id" SET NOT NULL;
+
+ALTER TABLE "audit_log" ADD FOREIGN KEY ("actor_id") REFERENCES "users" ("id");
@@ -0,0 +1,5 @@
+ALTER TABLE "audit_log" DROP CONSTRAINT "audit_log_actor_id_fkey";
+
+ALTER TABLE "audit_log" ALTER COLUMN "actor_id" DROP NOT NULL;
+
+ALTER TABLE "audit_log" ADD FOREIGN KEY ("actor_id") REFERENCES "users" ("id") ON DELETE SET NULL;
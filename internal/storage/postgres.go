@@ -3,19 +3,24 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"os"
 	"time"
 
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/samber/do/v2"
 	"github.com/sirupsen/logrus"
 	"github.com/wickedv43/yd-diploma/internal/config"
 	"github.com/wickedv43/yd-diploma/internal/entities"
 	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/metrics"
+	"github.com/wickedv43/yd-diploma/internal/password"
 	"github.com/wickedv43/yd-diploma/internal/storage/db"
+	"github.com/wickedv43/yd-diploma/internal/tracing"
 	"github.com/wickedv43/yd-diploma/internal/util"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type PostgresStorage struct {
@@ -23,6 +28,11 @@ type PostgresStorage struct {
 	Queries  *db.Queries
 	log      *logrus.Entry
 	cfg      *config.Config
+	hasher   *password.Manager
+	migrator *Migrator
+
+	queryDuration *prometheus.HistogramVec
+	tracer        trace.Tracer
 }
 
 func NewPostgresStorage(i do.Injector) (*PostgresStorage, error) {
@@ -36,6 +46,13 @@ func NewPostgresStorage(i do.Injector) (*PostgresStorage, error) {
 
 	storage.log = log
 	storage.cfg = cfg
+	storage.hasher = do.MustInvoke[*password.Manager](i)
+
+	switch cfg.Account.DeletePolicy {
+	case "", DeletePolicyAnonymize, DeletePolicyCascade:
+	default:
+		return nil, errors.Wrapf(ErrUnknownDeletePolicy, "%q", cfg.Account.DeletePolicy)
+	}
 
 	pgDB, err := sql.Open("postgres", storage.cfg.Database.DSN)
 	if err != nil {
@@ -44,12 +61,25 @@ func NewPostgresStorage(i do.Injector) (*PostgresStorage, error) {
 
 	storage.Postgres = pgDB
 
+	//pool stats and query durations
+	registry := do.MustInvoke[*metrics.Registry](i)
+	registry.MustRegister(collectors.NewDBStatsCollector(pgDB, metrics.Namespace))
+	storage.queryDuration = newQueryDuration(registry)
+	storage.tracer = do.MustInvoke[*tracing.Provider](i).Tracer("github.com/wickedv43/yd-diploma/internal/storage")
+
 	err = storage.Migrate()
 	if err != nil {
 		return nil, errors.Wrap(err, "migrate")
 	}
 
-	storage.Queries = db.New(pgDB)
+	storage.Queries = storage.queries(pgDB)
+
+	if cfg.Admin.Login != "" {
+		err = storage.promoteAdmin(context.Background(), cfg.Admin.Login)
+		if err != nil {
+			return nil, errors.Wrap(err, "promote admin")
+		}
+	}
 
 	return storage, err
 }
@@ -58,64 +88,101 @@ func (s *PostgresStorage) Close() error {
 	return s.Postgres.Close()
 }
 
-func (s *PostgresStorage) HealthCheck() error {
-	return s.Postgres.Ping()
+// Shutdown closes connections after services using storage are stopped
+func (s *PostgresStorage) Shutdown() error {
+	if err := s.Close(); err != nil {
+		return errors.Wrap(err, "close postgres")
+	}
+
+	s.log.Info("postgres connections closed")
+
+	return nil
 }
 
-func (s *PostgresStorage) Migrate() error {
-	//create tables in db
-	//open schema file
-	query, err := os.ReadFile("./internal/storage/schema/schema.sql")
-	if err != nil {
-		return errors.Wrap(err, "read schema")
+// HealthCheck pings postgres and checks that schema has all migrations of
+// this build
+func (s *PostgresStorage) HealthCheck(ctx context.Context) error {
+	if err := s.Postgres.PingContext(ctx); err != nil {
+		return errors.Wrap(err, "ping postgres")
 	}
 
-	//exec query
-	_, err = s.Postgres.Exec(string(query))
+	version, err := s.migrator.Version(ctx)
 	if err != nil {
-		//TODO: fix migration
-		return nil
+		return err
+	}
+
+	return checkSchemaVersion(version, s.migrator.Latest())
+}
+
+// checkSchemaVersion fails when migrations of this build aren't applied yet.
+// Newer schema is fine: during rolling deploy the first new instance migrates
+// up while old ones keep serving.
+func checkSchemaVersion(version, latest int) error {
+	if version < latest {
+		return errors.Errorf("schema version is %d, want %d", version, latest)
 	}
 
 	return nil
 }
 
+// Migrate applies pending migrations on start
+func (s *PostgresStorage) Migrate() error {
+	m, err := newMigrator(s.Postgres, s.log)
+	if err != nil {
+		return err
+	}
+	s.migrator = m
+
+	return m.Up(context.Background())
+}
+
 // TODO: uID int32?
 func (s *PostgresStorage) RegisterUser(ctx context.Context, au AuthData) (User, error) {
+	ctx, span := s.startSpan(ctx, "RegisterUser")
+	defer span.End()
+
+	hash, err := s.hasher.Hash(au.Password)
+	if err != nil {
+		return User{}, errors.Wrap(err, "hash password")
+	}
+
 	user, err := s.Queries.CreateUser(ctx, db.CreateUserParams{
 		Login:            au.Login,
-		Password:         au.Password,
+		Password:         hash,
 		BalanceCurrent:   0,
 		BalanceWithdrawn: 0,
 	})
 	if err != nil {
-		//if login already exist?
+		//if login already exist
+		if isUniqueViolation(err) {
+			return User{}, entities.ErrConflict
+		}
 
-		//if another problems
 		return User{}, errors.Wrap(err, "create user")
 	}
 
 	return User{
-		AuthData: AuthData{
-			Login:    user.Login,
-			Password: user.Password,
-		},
-		ID: int(user.ID),
+		Login: user.Login,
+		ID:    int(user.ID),
+		Role:  user.Role,
 		Balance: UserBalance{
-			Current:   int(user.BalanceCurrent),
-			Withdrawn: int(user.BalanceWithdrawn),
+			Current:   user.BalanceCurrent,
+			Withdrawn: user.BalanceWithdrawn,
 		},
-		Orders: nil,
-		Bills:  nil,
 	}, nil
 }
 
 func (s *PostgresStorage) LoginUser(ctx context.Context, au AuthData) (User, error) {
+	ctx, span := s.startSpan(ctx, "LoginUser")
+	defer span.End()
+
 	//get user
 	user, err := s.Queries.GetUserByLogin(ctx, au.Login)
 	if err != nil {
 		//if bad login
 		if errors.Is(err, sql.ErrNoRows) {
+			//same timing as for existing user
+			s.hasher.VerifyDummy(au.Password)
 			return User{}, entities.ErrBadLogin
 		}
 
@@ -123,95 +190,73 @@ func (s *PostgresStorage) LoginUser(ctx context.Context, au AuthData) (User, err
 	}
 
 	//auth check
-	if au.Password != user.Password || au.Login != user.Login {
+	ok, rehash, err := s.hasher.Verify(au.Password, user.Password)
+	if err != nil {
+		return User{}, errors.Wrap(err, "verify password")
+	}
+	if !ok {
 		return User{}, entities.ErrBadLogin
 	}
 
+	//legacy plaintext or outdated hash
+	if rehash {
+		s.rehashPassword(ctx, user.ID, au.Password)
+	}
+
 	return User{
-		AuthData: AuthData{
-			Login:    user.Login,
-			Password: user.Password,
-		},
-		ID: int(user.ID),
+		Login: user.Login,
+		ID:    int(user.ID),
+		Role:  user.Role,
 		Balance: UserBalance{
-			Current:   int(user.BalanceCurrent),
-			Withdrawn: int(user.BalanceWithdrawn),
+			Current:   user.BalanceCurrent,
+			Withdrawn: user.BalanceWithdrawn,
 		},
-		Orders: nil,
-		Bills:  nil,
 	}, nil
 }
 
-func (s *PostgresStorage) UserData(ctx context.Context, id int) (User, error) {
-	//get user
-	user, err := s.Queries.GetUserByID(ctx, int32(id))
+// rehashPassword upgrades stored hash, login doesn't fail if it can't
+func (s *PostgresStorage) rehashPassword(ctx context.Context, id int32, pass string) {
+	hash, err := s.hasher.Hash(pass)
 	if err != nil {
-		//if bad login
-		if errors.Is(err, sql.ErrNoRows) {
-			return User{}, entities.ErrBadLogin
-		}
-
-		return User{}, errors.Wrap(err, "get user by login")
+		s.log.Error(errors.Wrap(err, "hash password"))
+		return
 	}
 
-	//get user bills
-	uBillsPG, err := s.Queries.GetBillsByUserID(ctx, user.ID)
+	err = s.Queries.UpdateUserPassword(ctx, db.UpdateUserPasswordParams{
+		ID:       id,
+		Password: hash,
+	})
 	if err != nil {
-		//no bills
-		if errors.Is(err, sql.ErrNoRows) {
-			return User{}, entities.ErrNotFound
-		}
-
-		//another
-		return User{}, errors.Wrap(err, "get bills by user id")
+		s.log.Error(errors.Wrap(err, "update user password"))
+		return
 	}
-	//format bill
-	var bills []Bill
 
-	for _, bill := range uBillsPG {
-		bills = append(bills, Bill{
-			Order:       bill.OrderNumber,
-			Sum:         int(bill.Sum),
-			ProcessedAt: bill.ProcessedAt.String(),
-		})
-	}
+	s.log.Infof("password of user %d rehashed", id)
+}
 
-	//orders
-	ordersPG, err := s.Queries.GetOrdersByUserID(ctx, user.ID)
+// Balance reads the stored balance, it's kept in sync with the ledger by post
+func (s *PostgresStorage) Balance(ctx context.Context, userID int) (UserBalance, error) {
+	ctx, span := s.startSpan(ctx, "Balance")
+	defer span.End()
+
+	balance, err := s.Queries.GetUserBalance(ctx, int32(userID))
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return User{}, entities.ErrNotFound
+			return UserBalance{}, entities.ErrNotFound
 		}
-		return User{}, errors.Wrap(err, "get orders by user id")
-	}
-
-	var orders []Order
-
-	for _, order := range ordersPG {
-		orders = append(orders, Order{
-			Number:     order.Number,
-			Status:     order.Status,
-			Accrual:    int(order.Accrual),
-			UploadedAt: order.UploadedAt.String(),
-		})
+		return UserBalance{}, errors.Wrap(err, "get user balance")
 	}
 
-	return User{
-		AuthData: AuthData{
-			Login:    user.Login,
-			Password: user.Password,
-		},
-		ID: int(user.ID),
-		Balance: UserBalance{
-			Current:   int(user.BalanceCurrent),
-			Withdrawn: int(user.BalanceWithdrawn),
-		},
-		Orders: orders,
-		Bills:  bills,
+	return UserBalance{
+		Current:   balance.BalanceCurrent,
+		Withdrawn: balance.BalanceWithdrawn,
 	}, nil
 }
 
 func (s *PostgresStorage) CreateOrder(ctx context.Context, order Order) error {
+	ctx, span := s.startSpan(ctx, "CreateOrder")
+	defer span.End()
+
 	uploadedAt, err := time.Parse(time.RFC3339, order.UploadedAt)
 	if err != nil {
 		return errors.Wrap(err, "parse uploaded at")
@@ -220,66 +265,173 @@ func (s *PostgresStorage) CreateOrder(ctx context.Context, order Order) error {
 	_, err = s.Queries.CreateOrder(ctx, db.CreateOrderParams{
 		Number:     order.Number,
 		UserID:     int32(order.UserID),
-		Status:     "NEW",
-		Accrual:    int32(order.Accrual),
+		Status:     StatusNew,
+		Accrual:    order.Accrual,
 		UploadedAt: uploadedAt,
 	})
 	if err != nil {
-		//TODO: order number errors?
+		if !isUniqueViolation(err) {
+			return errors.Wrap(err, "create order")
+		}
+
+		//order number is taken, check who owns it
+		owner, err := s.Queries.GetOrderByNumber(ctx, order.Number)
+		if err != nil {
+			return errors.Wrap(err, "get existing order")
+		}
+
 		//if same number by user
+		if int(owner.UserID) == order.UserID {
+			return entities.ErrAlreadyExists
+		}
 
 		//if same number by another user
-
-		return errors.Wrap(err, "create order")
+		return entities.ErrConflict
 	}
 
 	return nil
 }
 
-func (s *PostgresStorage) ProcessPayment(ctx context.Context, bill Bill) error {
-	if !util.LuhnCheck(bill.Order) {
-		return errors.New("bill number incorrect")
+func (s *PostgresStorage) UnprocessedOrders(ctx context.Context, limit int) ([]Order, error) {
+	ctx, span := s.startSpan(ctx, "UnprocessedOrders")
+	defer span.End()
+
+	ordersPG, err := s.Queries.GetUnprocessedOrders(ctx, int32(limit))
+	if err != nil {
+		return nil, errors.Wrap(err, "get unprocessed orders")
 	}
 
-	//process payment with tx
+	var orders []Order
+
+	for _, order := range ordersPG {
+		orders = append(orders, Order{
+			UserID:     int(order.UserID),
+			Number:     order.Number,
+			Status:     order.Status,
+			Accrual:    order.Accrual,
+			UploadedAt: order.UploadedAt.Format(time.RFC3339),
+		})
+	}
+
+	return orders, nil
+}
+
+// CountUnprocessedOrders is the backlog of accrual poller
+func (s *PostgresStorage) CountUnprocessedOrders(ctx context.Context) (int64, error) {
+	ctx, span := s.startSpan(ctx, "CountUnprocessedOrders")
+	defer span.End()
+
+	n, err := s.Queries.CountUnprocessedOrders(ctx)
+	if err != nil {
+		return 0, errors.Wrap(err, "count unprocessed orders")
+	}
+
+	return n, nil
+}
+
+// UpdateOrder stores the accrual result for an order and credits the owner
+// when the order reaches PROCESSED. Orders that are already final are left as is.
+func (s *PostgresStorage) UpdateOrder(ctx context.Context, order Order) error {
+	ctx, span := s.startSpan(ctx, "UpdateOrder")
+	defer span.End()
+
 	tx, err := s.Postgres.BeginTx(ctx, nil)
 	if err != nil {
 		return errors.Wrap(err, "begin transaction")
 	}
+	defer tx.Rollback()
 
-	queriesWithTX := db.New(tx)
+	queriesWithTX := s.queries(tx)
 
-	//get order to get userID
-	order, err := queriesWithTX.GetOrderByNumber(ctx, bill.Order)
+	//lock order row, so concurrent workers can't credit twice
+	current, err := queriesWithTX.GetOrderByNumberForUpdate(ctx, order.Number)
 	if err != nil {
-		//mb log?
-		tx.Rollback()
+		if errors.Is(err, sql.ErrNoRows) {
+			return entities.ErrNotFound
+		}
 		return errors.Wrap(err, "get order")
 	}
 
-	//get user with balance
-	user, err := queriesWithTX.GetUserByID(ctx, order.UserID)
+	//final statuses never change
+	if current.Status == StatusInvalid || current.Status == StatusProcessed {
+		return nil
+	}
+
+	err = queriesWithTX.UpdateOrderAccrual(ctx, db.UpdateOrderAccrualParams{
+		Number:  order.Number,
+		Status:  order.Status,
+		Accrual: order.Accrual,
+	})
 	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "get user")
+		return errors.Wrap(err, "update order accrual")
+	}
+
+	//credit user
+	if order.Status == StatusProcessed && order.Accrual > 0 {
+		err = post(ctx, queriesWithTX, posting{
+			userID:      current.UserID,
+			kind:        LedgerAccrual,
+			account:     AccountAccruals,
+			amount:      order.Accrual,
+			orderNumber: order.Number,
+		})
+		if err != nil {
+			return errors.Wrap(err, "post accrual")
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return errors.Wrap(err, "commit transaction")
+	}
+
+	return nil
+}
+
+// ProcessPayment withdraws points from user balance against a new order.
+// Balance is checked by a conditional update, so parallel withdrawals
+// can't overspend.
+func (s *PostgresStorage) ProcessPayment(ctx context.Context, bill Bill) error {
+	ctx, span := s.startSpan(ctx, "ProcessPayment")
+	defer span.End()
+
+	if !util.LuhnCheck(bill.Order) {
+		return entities.ErrBadOrder
 	}
 
-	//check
-	if int(user.BalanceCurrent)-bill.Sum < 0 {
-		tx.Rollback()
-		return errors.New("insufficient balance")
+	//process payment with tx
+	tx, err := s.Postgres.BeginTx(ctx, nil)
+	if err != nil {
+		return errors.Wrap(err, "begin transaction")
 	}
+	defer tx.Rollback()
 
-	user.BalanceCurrent -= int32(bill.Sum)
+	queriesWithTX := s.queries(tx)
 
-	err = queriesWithTX.UpdateUserBalance(ctx, db.UpdateUserBalanceParams{
-		ID:               user.ID,
-		BalanceCurrent:   user.BalanceCurrent,
-		BalanceWithdrawn: user.BalanceWithdrawn,
+	//order number can be used for withdrawal only once
+	_, err = queriesWithTX.CreateBill(ctx, db.CreateBillParams{
+		OrderNumber: bill.Order,
+		UserID:      int32(bill.UserID),
+		Sum:         bill.Sum,
+		ProcessedAt: time.Now(),
 	})
 	if err != nil {
-		tx.Rollback()
-		return errors.Wrap(err, "update user balance")
+		if isUniqueViolation(err) {
+			return entities.ErrBadOrder
+		}
+		return errors.Wrap(err, "create bill")
+	}
+
+	//withdraw, user row is locked by update
+	err = post(ctx, queriesWithTX, posting{
+		userID:      int32(bill.UserID),
+		kind:        LedgerWithdrawal,
+		account:     AccountWithdrawals,
+		amount:      -bill.Sum,
+		withdrawn:   bill.Sum,
+		orderNumber: bill.Order,
+	})
+	if err != nil {
+		return errors.Wrap(err, "post withdrawal")
 	}
 
 	//if all success
@@ -289,3 +441,10 @@ func (s *PostgresStorage) ProcessPayment(ctx context.Context, bill Bill) error {
 
 	return nil
 }
+
+// isUniqueViolation checks postgres unique_violation error code
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+
+	return errors.As(err, &pqErr) && pqErr.Code == "23505"
+}
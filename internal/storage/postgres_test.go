@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+func TestCheckSchemaVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		version int
+		ready   bool
+	}{
+		{name: "behind", version: 8, ready: false},
+		{name: "current", version: 9, ready: true},
+		{name: "migrated by newer build", version: 10, ready: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSchemaVersion(tt.version, 9)
+			if ready := err == nil; ready != tt.ready {
+				t.Errorf("ready is %t, want %t: %v", ready, tt.ready, err)
+			}
+		})
+	}
+}
+
+// TestBaseline needs STORAGETEST_DSN of a database the test may wipe
+func TestBaseline(t *testing.T) {
+	dsn := os.Getenv("STORAGETEST_DSN")
+	if dsn == "" {
+		t.Skip("STORAGETEST_DSN is not set")
+	}
+
+	migrations, err := loadMigrations(migrationsFS)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	initial := migrations[0].Up
+
+	tests := []struct {
+		name     string
+		existing string
+		err      error
+	}{
+		{name: "empty database"},
+		{name: "schema of 0001", existing: initial},
+		{
+			name:     "build with NUMERIC money",
+			existing: initial + `ALTER TABLE "users" ALTER COLUMN "balance_current" TYPE NUMERIC(14, 2);`,
+			err:      ErrUnknownSchema,
+		},
+		{
+			name:     "build with a later table",
+			existing: initial + `CREATE TABLE "login_attempts" ("key" VARCHAR(320) PRIMARY KEY);`,
+			err:      ErrUnknownSchema,
+		},
+		{
+			name:     "build with a later column",
+			existing: initial + `ALTER TABLE "users" ADD COLUMN "role" VARCHAR(20);`,
+			err:      ErrUnknownSchema,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pgDB, err := sql.Open("postgres", dsn)
+			if err != nil {
+				t.Fatalf("connect to postgres: %v", err)
+			}
+
+			log := logrus.New()
+			log.SetOutput(io.Discard)
+
+			m, err := newMigrator(pgDB, logrus.NewEntry(log))
+			if err != nil {
+				t.Fatalf("new migrator: %v", err)
+			}
+			defer m.Close()
+
+			ctx := context.Background()
+			if _, err = pgDB.ExecContext(ctx, `DROP SCHEMA public CASCADE; CREATE SCHEMA public;`+tt.existing); err != nil {
+				t.Fatalf("prepare schema: %v", err)
+			}
+
+			err = m.Up(ctx)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+
+				//nothing is marked applied
+				if version, err := m.Version(ctx); err != nil || version != 0 {
+					t.Errorf("version is %d, error %v", version, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("up: %v", err)
+			}
+
+			if version, err := m.Version(ctx); err != nil || version != m.Latest() {
+				t.Errorf("version is %d, error %v, want %d", version, err, m.Latest())
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+-- queries/account.sql
+
+-- name: ChangeUserLogin :execrows
+UPDATE users
+SET login = $2
+WHERE id = $1
+  AND deleted_at IS NULL;
+
+-- name: AnonymizeUser :exec
+UPDATE users
+SET login = $2,
+    password = '',
+    deleted_at = $3
+WHERE id = $1;
+
+-- name: DeleteUserLedger :execrows
+WITH deleted AS (
+    DELETE FROM ledger_entries e
+    WHERE e.transaction_id IN (
+        SELECT u.transaction_id
+        FROM ledger_entries u
+        WHERE u.user_id = sqlc.arg(user_id)
+    )
+    RETURNING e.transaction_id
+)
+DELETE FROM ledger_transactions t
+WHERE t.id IN (SELECT d.transaction_id FROM deleted d);
+
+-- name: DeleteUser :exec
+DELETE FROM users
+WHERE id = $1;
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens
+SET revoked_at = $3
+WHERE user_id = $1
+  AND family_id <> $2
+  AND revoked_at IS NULL;
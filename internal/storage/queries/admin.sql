@@ -0,0 +1,30 @@
+-- queries/admin.sql
+
+-- name: GetUserRole :one
+SELECT role
+FROM users
+WHERE id = $1
+  AND deleted_at IS NULL;
+
+-- name: SetUserRole :execrows
+UPDATE users
+SET role = $2
+WHERE id = $1
+  AND deleted_at IS NULL;
+
+-- name: SetUserRoleByLogin :execrows
+UPDATE users
+SET role = $2
+WHERE login = $1
+  AND deleted_at IS NULL;
+
+-- name: CreateAuditRecord :exec
+INSERT INTO audit_log (actor_id, action, target_user_id, details)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetAuditLog :many
+SELECT id, actor_id, action, target_user_id, details, created_at
+FROM audit_log
+WHERE (sqlc.narg(target_user_id)::INTEGER IS NULL OR target_user_id = sqlc.narg(target_user_id))
+ORDER BY id DESC
+LIMIT $1;
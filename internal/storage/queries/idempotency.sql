@@ -0,0 +1,33 @@
+-- queries/idempotency.sql
+
+-- name: CreateIdempotencyKey :execrows
+INSERT INTO idempotency_keys (key, user_id, request_hash, created_at, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (key, user_id) DO UPDATE
+SET request_hash = EXCLUDED.request_hash,
+    status_code = 0,
+    content_type = '',
+    response_body = NULL,
+    created_at = EXCLUDED.created_at,
+    expires_at = EXCLUDED.expires_at
+WHERE idempotency_keys.expires_at < EXCLUDED.created_at;
+
+-- name: GetIdempotencyKey :one
+SELECT key, user_id, request_hash, status_code, content_type, response_body, created_at, expires_at
+FROM idempotency_keys
+WHERE key = $1 AND user_id = $2;
+
+-- name: SaveIdempotencyResponse :exec
+UPDATE idempotency_keys
+SET status_code = $3,
+    content_type = $4,
+    response_body = $5
+WHERE key = $1 AND user_id = $2;
+
+-- name: DeleteIdempotencyKey :exec
+DELETE FROM idempotency_keys
+WHERE key = $1 AND user_id = $2;
+
+-- name: DeleteExpiredIdempotencyKeys :execrows
+DELETE FROM idempotency_keys
+WHERE expires_at < $1;
@@ -0,0 +1,76 @@
+-- queries/ledger.sql
+
+-- name: CreateLedgerTransaction :one
+INSERT INTO ledger_transactions (kind, order_number, reverses_id, reason)
+VALUES ($1, $2, $3, $4)
+    RETURNING id, kind, order_number, reverses_id, reason, created_at;
+
+-- name: CreateLedgerEntry :exec
+INSERT INTO ledger_entries (transaction_id, account, user_id, amount)
+VALUES ($1, $2, $3, $4);
+
+-- name: GetLedgerTransactionForUpdate :one
+SELECT id, kind, order_number, reverses_id, reason, created_at
+FROM ledger_transactions
+WHERE id = $1
+FOR UPDATE;
+
+-- name: GetLedgerReversal :one
+SELECT id
+FROM ledger_transactions
+WHERE reverses_id = $1;
+
+-- name: GetLedgerEntriesByTransactionID :many
+SELECT id, transaction_id, account, user_id, amount
+FROM ledger_entries
+WHERE transaction_id = $1
+ORDER BY id;
+
+-- name: GetLedgerByUserID :many
+SELECT t.id, t.kind, t.order_number, t.reverses_id, t.reason, t.created_at, e.amount
+FROM ledger_entries e
+JOIN ledger_transactions t ON t.id = e.transaction_id
+WHERE e.user_id = $1
+ORDER BY t.created_at DESC, t.id DESC;
+
+-- name: GetLedgerBalance :one
+SELECT COALESCE(SUM(e.amount), 0)::NUMERIC AS current,
+       COALESCE(-SUM(e.amount) FILTER (WHERE t.kind = 'withdrawal' OR r.kind = 'withdrawal'), 0)::NUMERIC AS withdrawn
+FROM ledger_entries e
+JOIN ledger_transactions t ON t.id = e.transaction_id
+LEFT JOIN ledger_transactions r ON r.id = t.reverses_id
+WHERE e.user_id = $1;
+
+-- name: AddUserBalance :one
+UPDATE users
+SET balance_current = balance_current + sqlc.arg(current),
+    balance_withdrawn = balance_withdrawn + sqlc.arg(withdrawn)
+WHERE id = sqlc.arg(id)
+  AND balance_current + sqlc.arg(current) >= 0
+    RETURNING balance_current;
+
+-- name: GetLedgerMismatches :many
+SELECT u.id, u.balance_current, u.balance_withdrawn,
+       COALESCE(l.current, 0)::NUMERIC AS ledger_current,
+       COALESCE(l.withdrawn, 0)::NUMERIC AS ledger_withdrawn
+FROM users u
+LEFT JOIN (
+    SELECT e.user_id,
+           SUM(e.amount) AS current,
+           -SUM(e.amount) FILTER (WHERE t.kind = 'withdrawal' OR r.kind = 'withdrawal') AS withdrawn
+    FROM ledger_entries e
+    JOIN ledger_transactions t ON t.id = e.transaction_id
+    LEFT JOIN ledger_transactions r ON r.id = t.reverses_id
+    WHERE e.user_id IS NOT NULL
+    GROUP BY e.user_id
+) l ON l.user_id = u.id
+WHERE u.balance_current <> COALESCE(l.current, 0)
+   OR u.balance_withdrawn <> COALESCE(l.withdrawn, 0)
+ORDER BY u.id;
+
+-- name: GetUnbalancedLedgerTransactions :many
+SELECT transaction_id, SUM(amount)::NUMERIC AS total
+FROM ledger_entries
+GROUP BY transaction_id
+HAVING SUM(amount) <> 0
+ORDER BY transaction_id;
@@ -0,0 +1,49 @@
+-- queries/listing.sql
+
+-- pages are keyset paginated by (time, number), empty filters match everything
+
+-- name: ListOrdersDesc :many
+SELECT number, user_id, status, accrual, uploaded_at
+FROM orders
+WHERE user_id = sqlc.arg(user_id)
+  AND (cardinality(sqlc.arg(statuses)::TEXT[]) = 0 OR status = ANY(sqlc.arg(statuses)::TEXT[]))
+  AND (sqlc.narg(from_time)::TIMESTAMPTZ IS NULL OR uploaded_at >= sqlc.narg(from_time))
+  AND (sqlc.narg(to_time)::TIMESTAMPTZ IS NULL OR uploaded_at < sqlc.narg(to_time))
+  AND (sqlc.narg(after_time)::TIMESTAMPTZ IS NULL
+    OR (uploaded_at, number) < (sqlc.narg(after_time), sqlc.arg(after_number)::TEXT))
+ORDER BY uploaded_at DESC, number DESC
+LIMIT sqlc.arg(page_size);
+
+-- name: ListOrdersAsc :many
+SELECT number, user_id, status, accrual, uploaded_at
+FROM orders
+WHERE user_id = sqlc.arg(user_id)
+  AND (cardinality(sqlc.arg(statuses)::TEXT[]) = 0 OR status = ANY(sqlc.arg(statuses)::TEXT[]))
+  AND (sqlc.narg(from_time)::TIMESTAMPTZ IS NULL OR uploaded_at >= sqlc.narg(from_time))
+  AND (sqlc.narg(to_time)::TIMESTAMPTZ IS NULL OR uploaded_at < sqlc.narg(to_time))
+  AND (sqlc.narg(after_time)::TIMESTAMPTZ IS NULL
+    OR (uploaded_at, number) > (sqlc.narg(after_time), sqlc.arg(after_number)::TEXT))
+ORDER BY uploaded_at, number
+LIMIT sqlc.arg(page_size);
+
+-- name: ListBillsDesc :many
+SELECT order_number, user_id, sum, processed_at
+FROM bills
+WHERE user_id = sqlc.arg(user_id)
+  AND (sqlc.narg(from_time)::TIMESTAMPTZ IS NULL OR processed_at >= sqlc.narg(from_time))
+  AND (sqlc.narg(to_time)::TIMESTAMPTZ IS NULL OR processed_at < sqlc.narg(to_time))
+  AND (sqlc.narg(after_time)::TIMESTAMPTZ IS NULL
+    OR (processed_at, order_number) < (sqlc.narg(after_time), sqlc.arg(after_number)::TEXT))
+ORDER BY processed_at DESC, order_number DESC
+LIMIT sqlc.arg(page_size);
+
+-- name: ListBillsAsc :many
+SELECT order_number, user_id, sum, processed_at
+FROM bills
+WHERE user_id = sqlc.arg(user_id)
+  AND (sqlc.narg(from_time)::TIMESTAMPTZ IS NULL OR processed_at >= sqlc.narg(from_time))
+  AND (sqlc.narg(to_time)::TIMESTAMPTZ IS NULL OR processed_at < sqlc.narg(to_time))
+  AND (sqlc.narg(after_time)::TIMESTAMPTZ IS NULL
+    OR (processed_at, order_number) > (sqlc.narg(after_time), sqlc.arg(after_number)::TEXT))
+ORDER BY processed_at, order_number
+LIMIT sqlc.arg(page_size);
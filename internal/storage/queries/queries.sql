@@ -3,28 +3,31 @@
 -- name: CreateUser :one
 INSERT INTO users (login, password, balance_current, balance_withdrawn)
 VALUES ($1, $2, $3, $4)
-    RETURNING id, login, password, balance_current, balance_withdrawn;
+    RETURNING id, login, password, balance_current, balance_withdrawn, role;
 
 -- name: GetUserByID :one
-SELECT id, login, password, balance_current, balance_withdrawn
+SELECT id, login, password, balance_current, balance_withdrawn, role
 FROM users
-WHERE id = $1;
+WHERE id = $1
+  AND deleted_at IS NULL;
+
+-- name: GetUserBalance :one
+SELECT balance_current, balance_withdrawn
+FROM users
+WHERE id = $1
+  AND deleted_at IS NULL;
 
 -- name: GetUserByLogin :one
-SELECT id, login, password, balance_current, balance_withdrawn
+SELECT id, login, password, balance_current, balance_withdrawn, role
 FROM users
-WHERE login = $1;
+WHERE login = $1
+  AND deleted_at IS NULL;
 
 -- name: CreateOrder :one
 INSERT INTO orders (number, user_id, status, accrual, uploaded_at)
 VALUES ($1, $2, $3, $4, $5)
     RETURNING number, user_id, status, accrual, uploaded_at;
 
--- name: GetOrdersByUserID :many
-SELECT number, user_id, status, accrual, uploaded_at
-FROM orders
-WHERE user_id = $1;
-
 -- name: CreateBill :one
 INSERT INTO bills (order_number, user_id, sum, processed_at)
 VALUES ($1, $2, $3, $4)
@@ -40,12 +43,6 @@ SELECT number, user_id, status, accrual, uploaded_at
 FROM orders
 WHERE number = $1;
 
--- name: GetBillsByUserID :many
-SELECT id, order_number, user_id, sum, processed_at
-FROM bills
-WHERE user_id = $1
-ORDER BY processed_at DESC;
-
 -- name: GetAllBills :many
 SELECT id, order_number, user_id, sum, processed_at
 FROM bills
@@ -57,9 +54,36 @@ SELECT id, order_number, user_id, sum, processed_at
 FROM bills
 WHERE id = $1;
 
--- name: UpdateUserBalance :exec
+
+-- name: GetUnprocessedOrders :many
+SELECT o.number, o.user_id, o.status, o.accrual, o.uploaded_at
+FROM orders o
+JOIN users u ON u.id = o.user_id
+WHERE o.status IN ('NEW', 'PROCESSING')
+  AND u.deleted_at IS NULL
+ORDER BY o.uploaded_at
+LIMIT $1;
+
+-- name: CountUnprocessedOrders :one
+SELECT count(*)
+FROM orders o
+JOIN users u ON u.id = o.user_id
+WHERE o.status IN ('NEW', 'PROCESSING')
+  AND u.deleted_at IS NULL;
+
+-- name: GetOrderByNumberForUpdate :one
+SELECT number, user_id, status, accrual, uploaded_at
+FROM orders
+WHERE number = $1
+FOR UPDATE;
+
+-- name: UpdateOrderAccrual :exec
+UPDATE orders
+SET status = $2,
+    accrual = $3
+WHERE number = $1;
+
+-- name: UpdateUserPassword :exec
 UPDATE users
-SET balance_current = $2,
-    balance_withdrawn = $3
+SET password = $2
 WHERE id = $1;
-
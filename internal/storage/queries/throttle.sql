@@ -0,0 +1,38 @@
+-- queries/throttle.sql
+
+-- name: CreateLoginAttempt :exec
+INSERT INTO login_attempts (key, failures, last_failure_at)
+VALUES ($1, 0, $2)
+ON CONFLICT (key) DO NOTHING;
+
+-- name: GetLoginAttemptForUpdate :one
+SELECT key, failures, last_failure_at, locked_until
+FROM login_attempts
+WHERE key = $1
+FOR UPDATE;
+
+-- name: UpdateLoginAttempt :exec
+UPDATE login_attempts
+SET failures = $2,
+    last_failure_at = $3,
+    locked_until = $4
+WHERE key = $1;
+
+-- name: ReleaseLoginAttempt :exec
+UPDATE login_attempts
+SET failures = GREATEST(failures - 1, 0)
+WHERE key = $1;
+
+-- name: GetLoginAttempt :one
+SELECT key, failures, last_failure_at, locked_until
+FROM login_attempts
+WHERE key = $1;
+
+-- name: DeleteLoginAttempt :exec
+DELETE FROM login_attempts
+WHERE key = $1;
+
+-- name: DeleteStaleLoginAttempts :execrows
+DELETE FROM login_attempts
+WHERE last_failure_at < $1
+  AND (locked_until IS NULL OR locked_until < $1);
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage/db"
+)
+
+func (s *PostgresStorage) CreateRefreshToken(ctx context.Context, token RefreshToken) error {
+	ctx, span := s.startSpan(ctx, "CreateRefreshToken")
+	defer span.End()
+
+	err := s.Queries.CreateRefreshToken(ctx, db.CreateRefreshTokenParams{
+		TokenHash: token.Hash,
+		FamilyID:  token.FamilyID,
+		UserID:    int32(token.UserID),
+		ExpiresAt: token.ExpiresAt,
+	})
+	if err != nil {
+		return errors.Wrap(err, "create refresh token")
+	}
+
+	return nil
+}
+
+// RotateRefreshToken exchanges an active refresh token for the next one of the
+// same family. Presenting a token that was already used or revoked means it
+// leaked, so the whole family is revoked and entities.ErrTokenReused returned.
+func (s *PostgresStorage) RotateRefreshToken(ctx context.Context, hash string, next RefreshToken) (RefreshToken, error) {
+	ctx, span := s.startSpan(ctx, "RotateRefreshToken")
+	defer span.End()
+
+	tx, err := s.Postgres.BeginTx(ctx, nil)
+	if err != nil {
+		return RefreshToken{}, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	queriesWithTX := s.queries(tx)
+
+	current, err := queriesWithTX.GetRefreshTokenForUpdate(ctx, hash)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return RefreshToken{}, entities.ErrNotFound
+		}
+		return RefreshToken{}, errors.Wrap(err, "get refresh token")
+	}
+
+	now := time.Now()
+
+	//replay
+	if current.UsedAt.Valid || current.RevokedAt.Valid {
+		err = queriesWithTX.RevokeRefreshTokenFamily(ctx, db.RevokeRefreshTokenFamilyParams{
+			FamilyID:  current.FamilyID,
+			RevokedAt: sql.NullTime{Time: now, Valid: true},
+		})
+		if err != nil {
+			return RefreshToken{}, errors.Wrap(err, "revoke refresh token family")
+		}
+
+		if err = tx.Commit(); err != nil {
+			return RefreshToken{}, errors.Wrap(err, "commit transaction")
+		}
+
+		s.log.Warnf("refresh token reused, family %s of user %d revoked", current.FamilyID, current.UserID)
+
+		return RefreshToken{}, entities.ErrTokenReused
+	}
+
+	if current.ExpiresAt.Before(now) {
+		return RefreshToken{}, entities.ErrNotFound
+	}
+
+	err = queriesWithTX.MarkRefreshTokenUsed(ctx, db.MarkRefreshTokenUsedParams{
+		ID:     current.ID,
+		UsedAt: sql.NullTime{Time: now, Valid: true},
+	})
+	if err != nil {
+		return RefreshToken{}, errors.Wrap(err, "mark refresh token used")
+	}
+
+	next.FamilyID = current.FamilyID
+	next.UserID = int(current.UserID)
+
+	err = queriesWithTX.CreateRefreshToken(ctx, db.CreateRefreshTokenParams{
+		TokenHash: next.Hash,
+		FamilyID:  next.FamilyID,
+		UserID:    current.UserID,
+		ExpiresAt: next.ExpiresAt,
+	})
+	if err != nil {
+		return RefreshToken{}, errors.Wrap(err, "create refresh token")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return RefreshToken{}, errors.Wrap(err, "commit transaction")
+	}
+
+	return next, nil
+}
+
+func (s *PostgresStorage) RevokeRefreshFamily(ctx context.Context, familyID string) error {
+	ctx, span := s.startSpan(ctx, "RevokeRefreshFamily")
+	defer span.End()
+
+	err := s.Queries.RevokeRefreshTokenFamily(ctx, db.RevokeRefreshTokenFamilyParams{
+		FamilyID:  familyID,
+		RevokedAt: sql.NullTime{Time: time.Now(), Valid: true},
+	})
+	if err != nil {
+		return errors.Wrap(err, "revoke refresh token family")
+	}
+
+	return nil
+}
+
+// RevokeAccessToken keeps jti until the token would expire anyway
+func (s *PostgresStorage) RevokeAccessToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	ctx, span := s.startSpan(ctx, "RevokeAccessToken")
+	defer span.End()
+
+	err := s.Queries.RevokeAccessToken(ctx, db.RevokeAccessTokenParams{
+		Jti:       jti,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return errors.Wrap(err, "revoke access token")
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) IsAccessTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	ctx, span := s.startSpan(ctx, "IsAccessTokenRevoked")
+	defer span.End()
+
+	revoked, err := s.Queries.IsAccessTokenRevoked(ctx, jti)
+	if err != nil {
+		return false, errors.Wrap(err, "check access token")
+	}
+
+	return revoked, nil
+}
+
+func (s *PostgresStorage) DeleteExpiredTokens(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := s.startSpan(ctx, "DeleteExpiredTokens")
+	defer span.End()
+
+	refresh, err := s.Queries.DeleteExpiredRefreshTokens(ctx, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete expired refresh tokens")
+	}
+
+	revoked, err := s.Queries.DeleteExpiredRevokedTokens(ctx, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete expired revoked tokens")
+	}
+
+	return refresh + revoked, nil
+}
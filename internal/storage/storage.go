@@ -2,41 +2,147 @@ package storage
 
 import (
 	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/logger"
 )
 
 // TODO: userID primary key
 type User struct {
-	AuthData
-
+	Login   string      `json:"login"`
 	ID      int         `json:"id"`
+	Role    string      `json:"role"`
 	Balance UserBalance `json:"balance"`
-	Orders  []Order     `json:"orders"`
-	Bills   []Bill      `json:"bills"`
 }
 
+// AuthData is a login request, password is never serialized back
 type AuthData struct {
 	Login    string `json:"login"`
 	Password string `json:"password"`
 }
 
+func (a AuthData) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Login string `json:"login"`
+	}{a.Login})
+}
+
+func (a AuthData) String() string {
+	return a.Login
+}
+
 type UserBalance struct {
-	Current   int `json:"current"`
-	Withdrawn int `json:"withdrawn"`
+	Current   entities.Points `json:"current"`
+	Withdrawn entities.Points `json:"withdrawn"`
 }
 
 // Order status | NEW | PROCESSING | INVALID | PROCESSED
+const (
+	StatusNew        = "NEW"
+	StatusProcessing = "PROCESSING"
+	StatusInvalid    = "INVALID"
+	StatusProcessed  = "PROCESSED"
+)
+
 type Order struct {
-	UserID     int    `json:"-"`
-	Number     string `json:"number"`
-	Status     string `json:"status"`
-	Accrual    int    `json:"accrual,omitempty"`
-	UploadedAt string `json:"uploaded_at"`
+	UserID     int             `json:"-"`
+	Number     string          `json:"number"`
+	Status     string          `json:"status"`
+	Accrual    entities.Points `json:"accrual,omitempty"`
+	UploadedAt string          `json:"uploaded_at"`
+}
+
+// ledger transaction kinds
+const (
+	LedgerAccrual    = "accrual"
+	LedgerWithdrawal = "withdrawal"
+	LedgerAdjustment = "adjustment"
+	LedgerReversal   = "reversal"
+)
+
+// roles, each one includes permissions of the previous
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// ledger accounts, user account is the one with user_id
+const (
+	AccountUser        = "user"
+	AccountAccruals    = "accruals"
+	AccountWithdrawals = "withdrawals"
+	AccountAdjustments = "adjustments"
+)
+
+// LedgerEntry is a ledger transaction as seen from the user account
+type LedgerEntry struct {
+	ID          int64           `json:"id"`
+	Kind        string          `json:"kind"`
+	Amount      entities.Points `json:"amount"`
+	OrderNumber string          `json:"order,omitempty"`
+	ReversesID  int64           `json:"reverses,omitempty"`
+	Reason      string          `json:"reason,omitempty"`
+	CreatedAt   string          `json:"created_at"`
+}
+
+// LedgerMismatch is either a user with stored balance different from
+// the ledger or a transaction (TransactionID set) with legs not summing to zero
+type LedgerMismatch struct {
+	UserID        int
+	TransactionID int64
+	Stored        UserBalance
+	Derived       UserBalance
 }
 
 type Bill struct {
-	Order       string `json:"order"`
-	Sum         int    `json:"sum"`
-	ProcessedAt string `json:"processed_at"`
+	UserID      int             `json:"-"`
+	Order       string          `json:"order"`
+	Sum         entities.Points `json:"sum"`
+	ProcessedAt string          `json:"processed_at"`
+}
+
+// ListQuery is a page of user orders or withdrawals, zero filters match
+// everything. Without Limit and Cursor there is a single page of all rows.
+type ListQuery struct {
+	UserID int
+	// Statuses filters orders only
+	Statuses []string
+	// From is inclusive and To is exclusive
+	From time.Time
+	To   time.Time
+	// Asc lists oldest first
+	Asc    bool
+	Cursor string
+	Limit  int
+}
+
+// OrderPage is a page of orders, NextCursor is empty on the last page
+type OrderPage struct {
+	Orders     []Order
+	NextCursor string
+}
+
+// BillPage is a page of withdrawals, NextCursor is empty on the last page
+type BillPage struct {
+	Bills      []Bill
+	NextCursor string
+}
+
+// IdempotencyKey is a stored response for a retried request,
+// StatusCode is 0 while the first request is in flight
+type IdempotencyKey struct {
+	Key         string
+	UserID      int
+	RequestHash string
+	StatusCode  int
+	ContentType string
+	Response    []byte
+	ExpiresAt   time.Time
 }
 
 // TODO: postgres sqlc or gorm?
@@ -44,15 +150,118 @@ type DataKeeper interface {
 	//user
 	RegisterUser(context.Context, AuthData) (User, error)
 	LoginUser(context.Context, AuthData) (User, error)
-	UserData(context.Context, int) (User, error)
+	Balance(context.Context, int) (UserBalance, error)
+	ChangePassword(context.Context, int, string, string) error
+	ChangeLogin(context.Context, int, string) error
+	DeleteUser(context.Context, int, string) error
 
 	//order
 	CreateOrder(context.Context, Order) error
+	UnprocessedOrders(context.Context, int) ([]Order, error)
+	CountUnprocessedOrders(context.Context) (int64, error)
+	UpdateOrder(context.Context, Order) error
+	ListOrders(context.Context, ListQuery) (OrderPage, error)
 
 	//payment
 	ProcessPayment(context.Context, Bill) error
+	ListWithdrawals(context.Context, ListQuery) (BillPage, error)
+
+	//ledger
+	Ledger(context.Context, int) ([]LedgerEntry, error)
+	// AdjustBalance writes the audit record in the same transaction
+	AdjustBalance(context.Context, int, entities.Points, string, AuditRecord) error
+	ReverseTransaction(context.Context, int64, string) error
+	CheckLedger(context.Context) ([]LedgerMismatch, error)
+
+	//admin
+	UserRole(context.Context, int) (string, error)
+	SetUserRole(context.Context, int, string) error
+	UserByLogin(context.Context, string) (User, error)
+	Order(context.Context, string) (Order, error)
+	WriteAudit(context.Context, AuditRecord) error
+	AuditLog(context.Context, int, int) ([]AuditRecord, error)
+
+	//idempotency
+	ReserveIdempotencyKey(context.Context, IdempotencyKey) (IdempotencyKey, error)
+	SaveIdempotencyResponse(context.Context, IdempotencyKey) error
+	DeleteIdempotencyKey(context.Context, string, int) error
+	DeleteExpiredIdempotencyKeys(context.Context, time.Time) (int64, error)
+
+	//session
+	CreateRefreshToken(context.Context, RefreshToken) error
+	RotateRefreshToken(context.Context, string, RefreshToken) (RefreshToken, error)
+	RevokeRefreshFamily(context.Context, string) error
+	RevokeUserSessions(context.Context, int, string) error
+	RevokeAccessToken(context.Context, string, time.Time) error
+	IsAccessTokenRevoked(context.Context, string) (bool, error)
+	DeleteExpiredTokens(context.Context, time.Time) (int64, error)
 
 	//di
-	HealthCheck() error
+	HealthCheck(context.Context) error
+	Shutdown() error
 	Close() error
 }
+
+// RefreshToken is stored by hash only, tokens issued by rotation share FamilyID
+type RefreshToken struct {
+	Hash      string
+	FamilyID  string
+	UserID    int
+	ExpiresAt time.Time
+}
+
+// LoginAttempt counts login attempts by throttle key, attempts which
+// didn't fail are released
+type LoginAttempt struct {
+	Key           string
+	Failures      int
+	LastFailureAt time.Time
+	LockedUntil   time.Time
+}
+
+// Reserve counts an attempt at now and locks the key for lock(failures).
+// The counter starts over when the previous attempt happened before
+// windowStart. Attempt of a locked key isn't counted, ok is false then.
+func (a LoginAttempt) Reserve(now, windowStart time.Time, lock func(failures int) time.Duration) (LoginAttempt, bool) {
+	if a.LockedUntil.After(now) {
+		return a, false
+	}
+
+	if a.LastFailureAt.Before(windowStart) {
+		a.Failures = 0
+	}
+
+	a.Failures++
+	a.LastFailureAt = now
+
+	if d := lock(a.Failures); d > 0 {
+		a.LockedUntil = now.Add(d)
+	}
+
+	return a, true
+}
+
+// AuditRecord is an action of support staff
+type AuditRecord struct {
+	ID int64 `json:"id"`
+	// ActorID is 0 when the actor was deleted with cascade policy
+	ActorID      int             `json:"actor_id,omitempty"`
+	Action       string          `json:"action"`
+	TargetUserID int             `json:"target_user_id,omitempty"`
+	Details      json.RawMessage `json:"details"`
+	CreatedAt    string          `json:"created_at"`
+}
+
+// NewDataKeeper uses postgres when DSN is set and memory otherwise
+func NewDataKeeper(i do.Injector) (DataKeeper, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	if cfg.Database.DSN == "" {
+		do.MustInvoke[*logger.Logger](i).WithField("component", "storage").
+			Warn("database DSN is not set, data is kept in memory")
+
+		return NewMemoryStorage(i)
+	}
+
+	return NewPostgresStorage(i)
+}
@@ -0,0 +1,11 @@
+package storagetest_test
+
+import (
+	"testing"
+
+	"github.com/wickedv43/yd-diploma/internal/storage/storagetest"
+)
+
+func TestMemory(t *testing.T) {
+	storagetest.Run(t, storagetest.Memory)
+}
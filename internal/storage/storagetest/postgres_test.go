@@ -0,0 +1,12 @@
+package storagetest_test
+
+import (
+	"testing"
+
+	"github.com/wickedv43/yd-diploma/internal/storage/storagetest"
+)
+
+// TestPostgres needs STORAGETEST_DSN of a database the suite may wipe
+func TestPostgres(t *testing.T) {
+	storagetest.Run(t, storagetest.Postgres)
+}
@@ -0,0 +1,812 @@
+// Package storagetest is the conformance suite for storage.DataKeeper
+// backends. A backend is tested with
+//
+//	func TestMemory(t *testing.T) {
+//		storagetest.Run(t, storagetest.Memory)
+//	}
+//
+// the factory must return an empty storage for every call.
+package storagetest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/metrics"
+	"github.com/wickedv43/yd-diploma/internal/password"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/tracing"
+)
+
+type Factory func(t *testing.T) storage.DataKeeper
+
+// Run runs every conformance test against storages made by newKeeper
+func Run(t *testing.T, newKeeper Factory) {
+	tests := []struct {
+		name string
+		test func(*testing.T, storage.DataKeeper)
+	}{
+		{"RegisterConflict", testRegisterConflict},
+		{"Login", testLogin},
+		{"OrderOwnership", testOrderOwnership},
+		{"OrderAccrual", testOrderAccrual},
+		{"ConcurrentWithdrawals", testConcurrentWithdrawals},
+		{"WithdrawalErrors", testWithdrawalErrors},
+		{"OrdersOrdering", testOrdersOrdering},
+		{"BillsOrdering", testBillsOrdering},
+		{"ListOrders", testListOrders},
+		{"ListWithdrawals", testListWithdrawals},
+		{"ErrorMapping", testErrorMapping},
+		{"AdjustmentAudit", testAdjustmentAudit},
+		{"ReverseTwice", testReverseTwice},
+		{"DeleteUserLoginReuse", testDeleteUserLoginReuse},
+		{"Idempotency", testIdempotency},
+		{"RefreshTokenReuse", testRefreshTokenReuse},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keeper := newKeeper(t)
+			t.Cleanup(func() {
+				_ = keeper.Close()
+			})
+
+			tt.test(t, keeper)
+		})
+	}
+}
+
+var (
+	hasherOnce sync.Once
+	hasher     *password.Manager
+	hasherErr  error
+)
+
+func injector(t *testing.T, cfg *config.Config) do.Injector {
+	//dummy hash is slow, one manager is shared by all tests
+	hasherOnce.Do(func() {
+		hasher, hasherErr = password.New(password.AlgorithmBcrypt)
+	})
+	if hasherErr != nil {
+		t.Fatalf("new password manager: %v", hasherErr)
+	}
+
+	i := do.New()
+
+	do.ProvideValue(i, cfg)
+	do.ProvideValue(i, hasher)
+	do.Provide(i, metrics.NewRegistry)
+	do.Provide(i, tracing.NewProvider)
+	do.Provide(i, func(do.Injector) (*logger.Logger, error) {
+		l, err := logger.NewLogger(nil)
+		if err != nil {
+			return nil, err
+		}
+		l.SetOutput(io.Discard)
+		return l, nil
+	})
+
+	return i
+}
+
+// Memory is a Factory of storage.MemoryStorage
+func Memory(t *testing.T) storage.DataKeeper {
+	m, err := storage.NewMemoryStorage(injector(t, &config.Config{}))
+	if err != nil {
+		t.Fatalf("new memory storage: %v", err)
+	}
+
+	return m
+}
+
+// Postgres is a Factory of storage.PostgresStorage on STORAGETEST_DSN
+// database, the test is skipped when it's not set. Public schema of the
+// database is dropped before every test.
+func Postgres(t *testing.T) storage.DataKeeper {
+	dsn := os.Getenv("STORAGETEST_DSN")
+	if dsn == "" {
+		t.Skip("STORAGETEST_DSN is not set")
+	}
+
+	pgDB, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("connect to postgres: %v", err)
+	}
+	defer pgDB.Close()
+
+	if _, err = pgDB.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public;`); err != nil {
+		t.Fatalf("reset schema: %v", err)
+	}
+
+	cfg := &config.Config{}
+	cfg.Database.DSN = dsn
+
+	pg, err := storage.NewPostgresStorage(injector(t, cfg))
+	if err != nil {
+		t.Fatalf("new postgres storage: %v", err)
+	}
+
+	return pg
+}
+
+// LuhnNumber makes a valid order number from n
+func LuhnNumber(n int) string {
+	digits := strconv.Itoa(n)
+
+	sum := 0
+	for i := len(digits) - 1; i >= 0; i-- {
+		d := int(digits[i] - '0')
+		//check digit goes right, so the last digit here is doubled
+		if (len(digits)-i)%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+	}
+
+	return digits + strconv.Itoa((10-sum%10)%10)
+}
+
+func register(t *testing.T, keeper storage.DataKeeper, login string) storage.User {
+	t.Helper()
+
+	user, err := keeper.RegisterUser(context.Background(), storage.AuthData{
+		Login:    login,
+		Password: "password-" + login,
+	})
+	if err != nil {
+		t.Fatalf("register %s: %v", login, err)
+	}
+
+	return user
+}
+
+func createOrder(t *testing.T, keeper storage.DataKeeper, userID int, number string, uploadedAt time.Time) {
+	t.Helper()
+
+	err := keeper.CreateOrder(context.Background(), storage.Order{
+		UserID:     userID,
+		Number:     number,
+		UploadedAt: uploadedAt.Format(time.RFC3339),
+	})
+	if err != nil {
+		t.Fatalf("create order %s: %v", number, err)
+	}
+}
+
+// credit gives user points through a processed order
+func credit(t *testing.T, keeper storage.DataKeeper, userID int, number string, amount entities.Points) {
+	t.Helper()
+
+	createOrder(t, keeper, userID, number, time.Now())
+
+	err := keeper.UpdateOrder(context.Background(), storage.Order{
+		Number:  number,
+		Status:  storage.StatusProcessed,
+		Accrual: amount,
+	})
+	if err != nil {
+		t.Fatalf("process order %s: %v", number, err)
+	}
+}
+
+func expectErr(t *testing.T, err, target error, what string) {
+	t.Helper()
+
+	if !errors.Is(err, target) {
+		t.Errorf("%s: got error %v, want %v", what, err, target)
+	}
+}
+
+func balance(t *testing.T, keeper storage.DataKeeper, userID int) storage.UserBalance {
+	t.Helper()
+
+	b, err := keeper.Balance(context.Background(), userID)
+	if err != nil {
+		t.Fatalf("balance of %d: %v", userID, err)
+	}
+
+	return b
+}
+
+func testRegisterConflict(t *testing.T, keeper storage.DataKeeper) {
+	register(t, keeper, "alice")
+
+	_, err := keeper.RegisterUser(context.Background(), storage.AuthData{
+		Login:    "alice",
+		Password: "another",
+	})
+	expectErr(t, err, entities.ErrConflict, "duplicate login")
+}
+
+func testLogin(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	registered := register(t, keeper, "alice")
+
+	user, err := keeper.LoginUser(ctx, storage.AuthData{Login: "alice", Password: "password-alice"})
+	if err != nil {
+		t.Fatalf("login: %v", err)
+	}
+	if user.ID != registered.ID {
+		t.Errorf("login returned user %d, want %d", user.ID, registered.ID)
+	}
+
+	_, err = keeper.LoginUser(ctx, storage.AuthData{Login: "alice", Password: "wrong"})
+	expectErr(t, err, entities.ErrBadLogin, "wrong password")
+
+	_, err = keeper.LoginUser(ctx, storage.AuthData{Login: "bob", Password: "password-bob"})
+	expectErr(t, err, entities.ErrBadLogin, "unknown login")
+}
+
+func testOrderOwnership(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+	bob := register(t, keeper, "bob")
+
+	number := LuhnNumber(1001)
+	createOrder(t, keeper, alice.ID, number, time.Now())
+
+	order := storage.Order{
+		UserID:     alice.ID,
+		Number:     number,
+		UploadedAt: time.Now().Format(time.RFC3339),
+	}
+
+	err := keeper.CreateOrder(ctx, order)
+	expectErr(t, err, entities.ErrAlreadyExists, "same order by owner")
+
+	order.UserID = bob.ID
+	err = keeper.CreateOrder(ctx, order)
+	expectErr(t, err, entities.ErrConflict, "same order by another user")
+
+	stored, err := keeper.Order(ctx, number)
+	if err != nil {
+		t.Fatalf("get order: %v", err)
+	}
+	if stored.UserID != alice.ID || stored.Status != storage.StatusNew {
+		t.Errorf("order is %+v, want NEW order of user %d", stored, alice.ID)
+	}
+}
+
+func testOrderAccrual(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+
+	number := LuhnNumber(2001)
+	credit(t, keeper, alice.ID, number, 12345)
+
+	//final orders never change and never credit twice
+	err := keeper.UpdateOrder(ctx, storage.Order{
+		Number:  number,
+		Status:  storage.StatusProcessed,
+		Accrual: 12345,
+	})
+	if err != nil {
+		t.Fatalf("update final order: %v", err)
+	}
+
+	if got := balance(t, keeper, alice.ID); got.Current != 12345 || got.Withdrawn != 0 {
+		t.Errorf("balance is %+v, want current 123.45", got)
+	}
+
+	pending, err := keeper.UnprocessedOrders(ctx, 10)
+	if err != nil {
+		t.Fatalf("unprocessed orders: %v", err)
+	}
+	if len(pending) != 0 {
+		t.Errorf("got %d unprocessed orders, want 0", len(pending))
+	}
+}
+
+func testConcurrentWithdrawals(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+	credit(t, keeper, alice.ID, LuhnNumber(3001), entities.PointsFromInt(100))
+
+	const attempts = 25
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		ok       int
+		rejected int
+	)
+
+	for n := 0; n < attempts; n++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+
+			err := keeper.ProcessPayment(ctx, storage.Bill{
+				UserID: alice.ID,
+				Order:  LuhnNumber(4000 + n),
+				Sum:    entities.PointsFromInt(10),
+			})
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			switch {
+			case err == nil:
+				ok++
+			case errors.Is(err, entities.ErrHaveEnoughMoney):
+				rejected++
+			default:
+				t.Errorf("withdrawal %d: %v", n, err)
+			}
+		}(n)
+	}
+	wg.Wait()
+
+	if ok != 10 || rejected != attempts-10 {
+		t.Errorf("%d withdrawals succeeded and %d rejected, want 10 and %d", ok, rejected, attempts-10)
+	}
+
+	got := balance(t, keeper, alice.ID)
+	if got.Current != 0 || got.Withdrawn != entities.PointsFromInt(100) {
+		t.Errorf("balance is %+v, want current 0 and withdrawn 100", got)
+	}
+
+	mismatches, err := keeper.CheckLedger(ctx)
+	if err != nil {
+		t.Fatalf("check ledger: %v", err)
+	}
+	if len(mismatches) != 0 {
+		t.Errorf("ledger mismatches: %+v", mismatches)
+	}
+}
+
+func testWithdrawalErrors(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+	credit(t, keeper, alice.ID, LuhnNumber(5001), entities.PointsFromInt(100))
+
+	err := keeper.ProcessPayment(ctx, storage.Bill{UserID: alice.ID, Order: "12345", Sum: 100})
+	expectErr(t, err, entities.ErrBadOrder, "bad order number")
+
+	number := LuhnNumber(5002)
+	if err = keeper.ProcessPayment(ctx, storage.Bill{UserID: alice.ID, Order: number, Sum: 100}); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+
+	err = keeper.ProcessPayment(ctx, storage.Bill{UserID: alice.ID, Order: number, Sum: 100})
+	expectErr(t, err, entities.ErrBadOrder, "reused order number")
+
+	err = keeper.ProcessPayment(ctx, storage.Bill{UserID: alice.ID, Order: LuhnNumber(5003), Sum: entities.PointsFromInt(1000)})
+	expectErr(t, err, entities.ErrHaveEnoughMoney, "not enough points")
+
+	//failed withdrawals change nothing
+	got := balance(t, keeper, alice.ID)
+	if got.Current != entities.PointsFromInt(100)-100 || got.Withdrawn != 100 {
+		t.Errorf("balance is %+v, want current 99 and withdrawn 1", got)
+	}
+}
+
+func testOrdersOrdering(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	var want []string
+	for n := 0; n < 5; n++ {
+		number := LuhnNumber(6000 + n)
+		createOrder(t, keeper, alice.ID, number, start.Add(time.Duration(n)*time.Minute))
+		want = append([]string{number}, want...)
+	}
+
+	page, err := keeper.ListOrders(ctx, storage.ListQuery{UserID: alice.ID})
+	if err != nil {
+		t.Fatalf("list orders: %v", err)
+	}
+
+	var got []string
+	for _, o := range page.Orders {
+		got = append(got, o.Number)
+		if _, err = time.Parse(time.RFC3339, o.UploadedAt); err != nil {
+			t.Errorf("uploaded_at %q is not RFC3339", o.UploadedAt)
+		}
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("orders are %v, want newest first %v", got, want)
+	}
+}
+
+func testBillsOrdering(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+	credit(t, keeper, alice.ID, LuhnNumber(7001), entities.PointsFromInt(100))
+
+	var want []string
+	for n := 0; n < 3; n++ {
+		number := LuhnNumber(8000 + n)
+		if err := keeper.ProcessPayment(ctx, storage.Bill{UserID: alice.ID, Order: number, Sum: 100}); err != nil {
+			t.Fatalf("withdraw: %v", err)
+		}
+		want = append([]string{number}, want...)
+
+		//processed_at is set by storage
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	page, err := keeper.ListWithdrawals(ctx, storage.ListQuery{UserID: alice.ID})
+	if err != nil {
+		t.Fatalf("list withdrawals: %v", err)
+	}
+
+	var got []string
+	for _, b := range page.Bills {
+		got = append(got, b.Order)
+		if _, err = time.Parse(time.RFC3339, b.ProcessedAt); err != nil {
+			t.Errorf("processed_at %q is not RFC3339", b.ProcessedAt)
+		}
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("bills are %v, want newest first %v", got, want)
+	}
+}
+
+// listOrders walks all pages of q
+func listOrders(t *testing.T, keeper storage.DataKeeper, q storage.ListQuery) []string {
+	t.Helper()
+
+	var numbers []string
+	for {
+		page, err := keeper.ListOrders(context.Background(), q)
+		if err != nil {
+			t.Fatalf("list orders: %v", err)
+		}
+
+		for _, o := range page.Orders {
+			numbers = append(numbers, o.Number)
+		}
+
+		if page.NextCursor == "" {
+			return numbers
+		}
+		if len(page.Orders) != q.Limit {
+			t.Errorf("page of %d orders has next cursor, want %d", len(page.Orders), q.Limit)
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+func testListOrders(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+	bob := register(t, keeper, "bob")
+
+	start := time.Now().Add(-time.Hour).Truncate(time.Second)
+
+	//two orders per minute, the second one is processed
+	var newest, processed []string
+	for n := 0; n < 7; n++ {
+		number := LuhnNumber(9000 + n)
+		createOrder(t, keeper, alice.ID, number, start.Add(time.Duration(n/2)*time.Minute))
+		newest = append([]string{number}, newest...)
+
+		if n%2 == 1 {
+			err := keeper.UpdateOrder(ctx, storage.Order{Number: number, Status: storage.StatusProcessed, Accrual: 100})
+			if err != nil {
+				t.Fatalf("process order: %v", err)
+			}
+			processed = append(processed, number)
+		}
+	}
+	createOrder(t, keeper, bob.ID, LuhnNumber(9100), start)
+
+	//same time orders go by number
+	got := listOrders(t, keeper, storage.ListQuery{UserID: alice.ID, Limit: 2})
+	if fmt.Sprint(got) != fmt.Sprint(newest) {
+		t.Errorf("pages are %v, want %v", got, newest)
+	}
+
+	//unpaginated like before pagination
+	page, err := keeper.ListOrders(ctx, storage.ListQuery{UserID: alice.ID})
+	if err != nil {
+		t.Fatalf("list orders: %v", err)
+	}
+	if len(page.Orders) != len(newest) || page.NextCursor != "" {
+		t.Errorf("query without limit has %d orders and cursor %q, want %d and none", len(page.Orders), page.NextCursor, len(newest))
+	}
+
+	got = listOrders(t, keeper, storage.ListQuery{UserID: alice.ID, Limit: 3, Asc: true})
+	oldest := slices.Clone(newest)
+	slices.Reverse(oldest)
+	if fmt.Sprint(got) != fmt.Sprint(oldest) {
+		t.Errorf("asc pages are %v, want %v", got, oldest)
+	}
+
+	got = listOrders(t, keeper, storage.ListQuery{
+		UserID:   alice.ID,
+		Statuses: []string{storage.StatusProcessed},
+		Limit:    2,
+		Asc:      true,
+	})
+	if fmt.Sprint(got) != fmt.Sprint(processed) {
+		t.Errorf("processed orders are %v, want %v", got, processed)
+	}
+
+	//[1 min, 3 min) holds orders 2..5
+	got = listOrders(t, keeper, storage.ListQuery{
+		UserID: alice.ID,
+		From:   start.Add(time.Minute),
+		To:     start.Add(3 * time.Minute),
+		Limit:  10,
+	})
+	if want := newest[1:5]; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("orders in range are %v, want %v", got, want)
+	}
+
+	_, err = keeper.ListOrders(ctx, storage.ListQuery{UserID: alice.ID, Cursor: "not a cursor"})
+	expectErr(t, err, storage.ErrBadCursor, "bad cursor")
+}
+
+func testListWithdrawals(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+	bob := register(t, keeper, "bob")
+	credit(t, keeper, alice.ID, LuhnNumber(9201), entities.PointsFromInt(100))
+	credit(t, keeper, bob.ID, LuhnNumber(9202), entities.PointsFromInt(100))
+
+	//processed_at is set by storage, bills of one second go by number
+	want := map[string]bool{}
+	for n := 0; n < 5; n++ {
+		number := LuhnNumber(9300 + n)
+		if err := keeper.ProcessPayment(ctx, storage.Bill{UserID: alice.ID, Order: number, Sum: 100}); err != nil {
+			t.Fatalf("withdraw: %v", err)
+		}
+		want[number] = true
+	}
+	if err := keeper.ProcessPayment(ctx, storage.Bill{UserID: bob.ID, Order: LuhnNumber(9400), Sum: 100}); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+
+	q := storage.ListQuery{UserID: alice.ID, Limit: 2}
+	got := map[string]bool{}
+	for {
+		page, err := keeper.ListWithdrawals(ctx, q)
+		if err != nil {
+			t.Fatalf("list withdrawals: %v", err)
+		}
+
+		for _, b := range page.Bills {
+			if got[b.Order] {
+				t.Errorf("bill %s is listed twice", b.Order)
+			}
+			got[b.Order] = true
+		}
+
+		if page.NextCursor == "" {
+			break
+		}
+		q.Cursor = page.NextCursor
+	}
+
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("bills are %v, want %v", got, want)
+	}
+
+	page, err := keeper.ListWithdrawals(ctx, storage.ListQuery{UserID: alice.ID, From: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("list withdrawals: %v", err)
+	}
+	if len(page.Bills) != 0 {
+		t.Errorf("future range has %d bills, want none", len(page.Bills))
+	}
+}
+
+func testErrorMapping(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	const unknownUser = 100500
+
+	_, err := keeper.Balance(ctx, unknownUser)
+	expectErr(t, err, entities.ErrNotFound, "balance of unknown user")
+
+	_, err = keeper.UserRole(ctx, unknownUser)
+	expectErr(t, err, entities.ErrNotFound, "role of unknown user")
+
+	_, err = keeper.UserByLogin(ctx, "nobody")
+	expectErr(t, err, entities.ErrNotFound, "unknown login")
+
+	_, err = keeper.Order(ctx, LuhnNumber(9001))
+	expectErr(t, err, entities.ErrNotFound, "unknown order")
+
+	err = keeper.UpdateOrder(ctx, storage.Order{Number: LuhnNumber(9001), Status: storage.StatusProcessing})
+	expectErr(t, err, entities.ErrNotFound, "update unknown order")
+
+	err = keeper.ReverseTransaction(ctx, 100500, "test")
+	expectErr(t, err, entities.ErrNotFound, "reverse unknown transaction")
+
+	alice := register(t, keeper, "alice")
+	err = keeper.AdjustBalance(ctx, alice.ID, -1, "test", storage.AuditRecord{ActorID: alice.ID, Action: "balance.adjust"})
+	expectErr(t, err, entities.ErrHaveEnoughMoney, "negative balance")
+}
+
+func testAdjustmentAudit(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	admin := register(t, keeper, "admin")
+	alice := register(t, keeper, "alice")
+
+	record := storage.AuditRecord{
+		ActorID:      admin.ID,
+		Action:       "balance.adjust",
+		TargetUserID: alice.ID,
+		Details:      []byte(`{"amount":"5"}`),
+	}
+
+	if err := keeper.AdjustBalance(ctx, alice.ID, entities.PointsFromInt(5), "bonus", record); err != nil {
+		t.Fatalf("adjust balance: %v", err)
+	}
+
+	//rejected adjustment leaves no record
+	err := keeper.AdjustBalance(ctx, alice.ID, entities.PointsFromInt(-10), "penalty", record)
+	expectErr(t, err, entities.ErrHaveEnoughMoney, "negative balance")
+
+	records, err := keeper.AuditLog(ctx, alice.ID, 10)
+	if err != nil {
+		t.Fatalf("audit log: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("audit log has %d records, want 1", len(records))
+	}
+	if got := records[0]; got.ActorID != admin.ID || got.Action != record.Action || got.TargetUserID != alice.ID {
+		t.Errorf("audit record is %+v", got)
+	}
+
+	if got := balance(t, keeper, alice.ID); got.Current != entities.PointsFromInt(5) {
+		t.Errorf("balance is %s, want 5", got.Current)
+	}
+}
+
+func testReverseTwice(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+
+	err := keeper.AdjustBalance(ctx, alice.ID, entities.PointsFromInt(5), "bonus", storage.AuditRecord{
+		Action:       "balance.adjust",
+		TargetUserID: alice.ID,
+	})
+	if err != nil {
+		t.Fatalf("adjust balance: %v", err)
+	}
+
+	ledger, err := keeper.Ledger(ctx, alice.ID)
+	if err != nil || len(ledger) != 1 {
+		t.Fatalf("ledger is %+v, error %v", ledger, err)
+	}
+	adjustment := ledger[0].ID
+
+	if err = keeper.ReverseTransaction(ctx, adjustment, "mistake"); err != nil {
+		t.Fatalf("reverse: %v", err)
+	}
+
+	err = keeper.ReverseTransaction(ctx, adjustment, "mistake")
+	expectErr(t, err, entities.ErrConflict, "second reversal")
+
+	ledger, err = keeper.Ledger(ctx, alice.ID)
+	if err != nil || len(ledger) != 2 {
+		t.Fatalf("ledger is %+v, error %v", ledger, err)
+	}
+	reversal := ledger[0]
+	if reversal.Kind != storage.LedgerReversal || reversal.ReversesID != adjustment {
+		t.Fatalf("last ledger entry is %+v, want reversal of %d", reversal, adjustment)
+	}
+
+	err = keeper.ReverseTransaction(ctx, reversal.ID, "mistake")
+	expectErr(t, err, entities.ErrConflict, "reversal of reversal")
+
+	if got := balance(t, keeper, alice.ID); got.Current != 0 {
+		t.Errorf("balance is %s, want 0", got.Current)
+	}
+}
+
+func testDeleteUserLoginReuse(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+
+	//somebody took what could be the anonymous login of alice
+	register(t, keeper, fmt.Sprintf("deleted-%d", alice.ID))
+
+	if err := keeper.DeleteUser(ctx, alice.ID, "password-alice"); err != nil {
+		t.Fatalf("delete user: %v", err)
+	}
+
+	_, err := keeper.UserByLogin(ctx, "alice")
+	expectErr(t, err, entities.ErrNotFound, "deleted login")
+
+	//login is free again
+	register(t, keeper, "alice")
+}
+
+func testIdempotency(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+
+	key := storage.IdempotencyKey{
+		Key:         "key",
+		UserID:      alice.ID,
+		RequestHash: "hash",
+		ExpiresAt:   time.Now().Add(time.Hour),
+	}
+
+	if _, err := keeper.ReserveIdempotencyKey(ctx, key); err != nil {
+		t.Fatalf("reserve: %v", err)
+	}
+
+	key.StatusCode = 200
+	key.ContentType = "application/json"
+	key.Response = []byte("null")
+	if err := keeper.SaveIdempotencyResponse(ctx, key); err != nil {
+		t.Fatalf("save response: %v", err)
+	}
+
+	another := key
+	another.RequestHash = "another"
+
+	stored, err := keeper.ReserveIdempotencyKey(ctx, another)
+	expectErr(t, err, entities.ErrAlreadyExists, "reserved key")
+	if stored.RequestHash != "hash" || stored.StatusCode != 200 || string(stored.Response) != "null" {
+		t.Errorf("stored key is %+v", stored)
+	}
+
+	deleted, err := keeper.DeleteExpiredIdempotencyKeys(ctx, time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("delete expired: %v", err)
+	}
+	if deleted != 1 {
+		t.Errorf("deleted %d keys, want 1", deleted)
+	}
+}
+
+func testRefreshTokenReuse(t *testing.T, keeper storage.DataKeeper) {
+	ctx := context.Background()
+	alice := register(t, keeper, "alice")
+
+	expiresAt := time.Now().Add(time.Hour)
+
+	err := keeper.CreateRefreshToken(ctx, storage.RefreshToken{
+		Hash:      "first",
+		FamilyID:  "family",
+		UserID:    alice.ID,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		t.Fatalf("create refresh token: %v", err)
+	}
+
+	next, err := keeper.RotateRefreshToken(ctx, "first", storage.RefreshToken{Hash: "second", ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatalf("rotate: %v", err)
+	}
+	if next.UserID != alice.ID || next.FamilyID != "family" {
+		t.Errorf("rotated token is %+v", next)
+	}
+
+	_, err = keeper.RotateRefreshToken(ctx, "first", storage.RefreshToken{Hash: "third", ExpiresAt: expiresAt})
+	expectErr(t, err, entities.ErrTokenReused, "replayed token")
+
+	//whole family is revoked
+	_, err = keeper.RotateRefreshToken(ctx, "second", storage.RefreshToken{Hash: "fourth", ExpiresAt: expiresAt})
+	expectErr(t, err, entities.ErrTokenReused, "token of revoked family")
+
+	_, err = keeper.RotateRefreshToken(ctx, "unknown", storage.RefreshToken{Hash: "fifth", ExpiresAt: expiresAt})
+	expectErr(t, err, entities.ErrNotFound, "unknown token")
+}
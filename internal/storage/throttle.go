@@ -0,0 +1,120 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage/db"
+)
+
+// ReserveLoginAttempt counts an attempt with LoginAttempt.Reserve. The row
+// is locked until the lockout is stored, so parallel attempts see each other.
+func (s *PostgresStorage) ReserveLoginAttempt(ctx context.Context, key string, now, windowStart time.Time, lock func(int) time.Duration) (LoginAttempt, bool, error) {
+	ctx, span := s.startSpan(ctx, "ReserveLoginAttempt")
+	defer span.End()
+
+	tx, err := s.Postgres.BeginTx(ctx, nil)
+	if err != nil {
+		return LoginAttempt{}, false, errors.Wrap(err, "begin transaction")
+	}
+	defer tx.Rollback()
+
+	queriesWithTX := s.queries(tx)
+
+	err = queriesWithTX.CreateLoginAttempt(ctx, db.CreateLoginAttemptParams{
+		Key:           key,
+		LastFailureAt: now,
+	})
+	if err != nil {
+		return LoginAttempt{}, false, errors.Wrap(err, "create login attempt")
+	}
+
+	stored, err := queriesWithTX.GetLoginAttemptForUpdate(ctx, key)
+	if err != nil {
+		return LoginAttempt{}, false, errors.Wrap(err, "get login attempt")
+	}
+
+	attempt, ok := loginAttempt(stored).Reserve(now, windowStart, lock)
+	if !ok {
+		return attempt, false, nil
+	}
+
+	err = queriesWithTX.UpdateLoginAttempt(ctx, db.UpdateLoginAttemptParams{
+		Key:           key,
+		Failures:      int32(attempt.Failures),
+		LastFailureAt: attempt.LastFailureAt,
+		LockedUntil:   sql.NullTime{Time: attempt.LockedUntil, Valid: !attempt.LockedUntil.IsZero()},
+	})
+	if err != nil {
+		return LoginAttempt{}, false, errors.Wrap(err, "update login attempt")
+	}
+
+	if err = tx.Commit(); err != nil {
+		return LoginAttempt{}, false, errors.Wrap(err, "commit transaction")
+	}
+
+	return attempt, true, nil
+}
+
+// ReleaseLoginAttempt uncounts an attempt which didn't fail, its lockout
+// is kept
+func (s *PostgresStorage) ReleaseLoginAttempt(ctx context.Context, key string) error {
+	ctx, span := s.startSpan(ctx, "ReleaseLoginAttempt")
+	defer span.End()
+
+	if err := s.Queries.ReleaseLoginAttempt(ctx, key); err != nil {
+		return errors.Wrap(err, "release login attempt")
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) LoginAttempt(ctx context.Context, key string) (LoginAttempt, error) {
+	ctx, span := s.startSpan(ctx, "LoginAttempt")
+	defer span.End()
+
+	attempt, err := s.Queries.GetLoginAttempt(ctx, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return LoginAttempt{}, entities.ErrNotFound
+		}
+		return LoginAttempt{}, errors.Wrap(err, "get login attempt")
+	}
+
+	return loginAttempt(attempt), nil
+}
+
+func (s *PostgresStorage) ResetLoginAttempts(ctx context.Context, key string) error {
+	ctx, span := s.startSpan(ctx, "ResetLoginAttempts")
+	defer span.End()
+
+	if err := s.Queries.DeleteLoginAttempt(ctx, key); err != nil {
+		return errors.Wrap(err, "delete login attempt")
+	}
+
+	return nil
+}
+
+func (s *PostgresStorage) DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int64, error) {
+	ctx, span := s.startSpan(ctx, "DeleteStaleLoginAttempts")
+	defer span.End()
+
+	deleted, err := s.Queries.DeleteStaleLoginAttempts(ctx, before)
+	if err != nil {
+		return 0, errors.Wrap(err, "delete stale login attempts")
+	}
+
+	return deleted, nil
+}
+
+func loginAttempt(a db.LoginAttempt) LoginAttempt {
+	return LoginAttempt{
+		Key:           a.Key,
+		Failures:      int(a.Failures),
+		LastFailureAt: a.LastFailureAt,
+		LockedUntil:   a.LockedUntil.Time,
+	}
+}
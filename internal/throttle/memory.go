@@ -0,0 +1,89 @@
+package throttle
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+)
+
+type MemoryStore struct {
+	mu       sync.Mutex
+	attempts map[string]storage.LoginAttempt
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		attempts: make(map[string]storage.LoginAttempt),
+	}
+}
+
+func (m *MemoryStore) ReserveLoginAttempt(_ context.Context, key string, now, windowStart time.Time, lock func(int) time.Duration) (storage.LoginAttempt, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, ok := m.attempts[key]
+	if !ok {
+		attempt.Key = key
+	}
+
+	attempt, ok = attempt.Reserve(now, windowStart, lock)
+	if ok {
+		m.attempts[key] = attempt
+	}
+
+	return attempt, ok, nil
+}
+
+func (m *MemoryStore) ReleaseLoginAttempt(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, ok := m.attempts[key]
+	if !ok || attempt.Failures == 0 {
+		return nil
+	}
+
+	attempt.Failures--
+	m.attempts[key] = attempt
+
+	return nil
+}
+
+func (m *MemoryStore) LoginAttempt(_ context.Context, key string) (storage.LoginAttempt, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	attempt, ok := m.attempts[key]
+	if !ok {
+		return storage.LoginAttempt{}, entities.ErrNotFound
+	}
+
+	return attempt, nil
+}
+
+func (m *MemoryStore) ResetLoginAttempts(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.attempts, key)
+
+	return nil
+}
+
+func (m *MemoryStore) DeleteStaleLoginAttempts(_ context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted int64
+	for key, attempt := range m.attempts {
+		if attempt.LastFailureAt.Before(before) && attempt.LockedUntil.Before(before) {
+			delete(m.attempts, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,209 @@
+package throttle
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+)
+
+const (
+	StorePostgres = "postgres"
+	StoreMemory   = "memory"
+)
+
+var ErrUnknownStore = errors.New("unknown throttle store")
+
+// Store keeps login attempt counters, *storage.PostgresStorage shares them
+// between replicas, MemoryStore is for a single instance
+type Store interface {
+	// ReserveLoginAttempt applies storage.LoginAttempt.Reserve atomically
+	ReserveLoginAttempt(ctx context.Context, key string, now, windowStart time.Time, lock func(int) time.Duration) (storage.LoginAttempt, bool, error)
+	ReleaseLoginAttempt(ctx context.Context, key string) error
+	LoginAttempt(ctx context.Context, key string) (storage.LoginAttempt, error)
+	ResetLoginAttempts(ctx context.Context, key string) error
+	DeleteStaleLoginAttempts(ctx context.Context, before time.Time) (int64, error)
+}
+
+// Throttler slows down password guessing. Attempts are counted per login and
+// per client IP before the password is checked, so parallel requests can't
+// get more attempts than allowed. After the free attempts every attempt
+// locks the key for an exponentially growing delay up to MaxLockout.
+type Throttler struct {
+	cfg   config.Throttle
+	store Store
+	log   *logrus.Entry
+}
+
+func NewThrottler(i do.Injector) (*Throttler, error) {
+	cfg := do.MustInvoke[*config.Config](i)
+
+	t := &Throttler{
+		cfg: cfg.Throttle,
+		log: do.MustInvoke[*logger.Logger](i).WithField("component", "throttle"),
+	}
+
+	switch cfg.Throttle.Store {
+	case "", StorePostgres:
+		pg, ok := do.MustInvoke[storage.DataKeeper](i).(*storage.PostgresStorage)
+		if ok {
+			t.store = pg
+			break
+		}
+
+		t.log.Warn("storage is not postgres, failed logins are kept in memory")
+		t.store = NewMemoryStore()
+	case StoreMemory:
+		t.store = NewMemoryStore()
+	default:
+		return nil, errors.Wrapf(ErrUnknownStore, "%q", cfg.Throttle.Store)
+	}
+
+	return t, nil
+}
+
+func loginKey(login string) string {
+	return "login:" + login
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Attempt is a reserved login attempt
+type Attempt struct {
+	// Wait is set when login or IP is locked, the attempt is rejected then
+	Wait time.Duration
+	// Lock is the lockout the attempt started, it lasts if the attempt fails
+	Lock time.Duration
+
+	login string
+	ip    string
+}
+
+// Reserve counts an attempt of login from ip
+func (t *Throttler) Reserve(ctx context.Context, login, ip string) (Attempt, error) {
+	now := time.Now()
+	a := Attempt{login: login, ip: ip}
+
+	loginAttempt, ok, err := t.reserve(ctx, loginKey(login), t.cfg.LoginAttempts, now)
+	if err != nil {
+		return Attempt{}, err
+	}
+	if !ok {
+		return t.throttled(a, loginAttempt.LockedUntil.Sub(now)), nil
+	}
+
+	ipAttempt, ok, err := t.reserve(ctx, ipKey(ip), t.cfg.IPAttempts, now)
+	if err != nil {
+		return Attempt{}, err
+	}
+	if !ok {
+		//login isn't tried
+		if err = t.store.ReleaseLoginAttempt(ctx, loginKey(login)); err != nil {
+			return Attempt{}, errors.Wrap(err, "release login attempt")
+		}
+		return t.throttled(a, ipAttempt.LockedUntil.Sub(now)), nil
+	}
+
+	a.Lock = max(loginAttempt.LockedUntil.Sub(now), ipAttempt.LockedUntil.Sub(now), 0)
+
+	return a, nil
+}
+
+func (t *Throttler) reserve(ctx context.Context, key string, free int, now time.Time) (storage.LoginAttempt, bool, error) {
+	attempt, ok, err := t.store.ReserveLoginAttempt(ctx, key, now, now.Add(-t.cfg.Window), func(failures int) time.Duration {
+		return t.delay(failures, free)
+	})
+	if err != nil {
+		return storage.LoginAttempt{}, false, errors.Wrap(err, "reserve login attempt")
+	}
+
+	return attempt, ok, nil
+}
+
+func (t *Throttler) throttled(a Attempt, wait time.Duration) Attempt {
+	a.Wait = wait
+
+	t.log.WithFields(logrus.Fields{
+		"event": "login_throttled",
+		"login": a.login,
+		"ip":    a.ip,
+	}).Warnf("login attempt rejected, retry after %s", wait)
+
+	return a
+}
+
+// Fail returns the lockout caused by the failed attempt
+func (t *Throttler) Fail(a Attempt) time.Duration {
+	entry := t.log.WithFields(logrus.Fields{
+		"event": "login_failed",
+		"login": a.login,
+		"ip":    a.ip,
+	})
+	if a.Lock > 0 {
+		entry.WithField("event", "login_locked").Warnf("login locked for %s", a.Lock)
+	} else {
+		entry.Info("login failed")
+	}
+
+	return a.Lock
+}
+
+// delay doubles with every failure after the free ones
+func (t *Throttler) delay(failures, free int) time.Duration {
+	over := failures - free
+	if over <= 0 {
+		return 0
+	}
+
+	d := t.cfg.BaseDelay
+	for n := 1; n < over && d < t.cfg.MaxLockout; n++ {
+		d *= 2
+	}
+
+	return min(d, t.cfg.MaxLockout)
+}
+
+// Succeed forgets failures of the login. IP counter only gets the attempt
+// back, so one valid account can't be used to reset it.
+func (t *Throttler) Succeed(ctx context.Context, a Attempt) error {
+	if err := t.store.ResetLoginAttempts(ctx, loginKey(a.login)); err != nil {
+		return errors.Wrap(err, "reset login attempts")
+	}
+
+	if err := t.store.ReleaseLoginAttempt(ctx, ipKey(a.ip)); err != nil {
+		return errors.Wrap(err, "release login attempt")
+	}
+
+	return nil
+}
+
+// Unlock lifts the login lockout on admin request
+func (t *Throttler) Unlock(ctx context.Context, login string) error {
+	if err := t.store.ResetLoginAttempts(ctx, loginKey(login)); err != nil {
+		return errors.Wrap(err, "reset login attempts")
+	}
+
+	t.log.WithFields(logrus.Fields{
+		"event": "login_unlocked",
+		"login": login,
+	}).Warn("login unlocked by admin")
+
+	return nil
+}
+
+// Cleanup removes counters which are out of window and not locked
+func (t *Throttler) Cleanup(ctx context.Context, now time.Time) (int64, error) {
+	deleted, err := t.store.DeleteStaleLoginAttempts(ctx, now.Add(-t.cfg.Window))
+	if err != nil {
+		return 0, errors.Wrap(err, "delete stale login attempts")
+	}
+
+	return deleted, nil
+}
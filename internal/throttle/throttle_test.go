@@ -0,0 +1,238 @@
+package throttle
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/storage/storagetest"
+)
+
+// stores are tested alike, postgres runs when STORAGETEST_DSN is set
+var stores = []struct {
+	name     string
+	newStore func(t *testing.T) Store
+}{
+	{name: "memory", newStore: func(*testing.T) Store { return NewMemoryStore() }},
+	{name: "postgres", newStore: func(t *testing.T) Store {
+		keeper := storagetest.Postgres(t)
+		t.Cleanup(func() {
+			_ = keeper.Close()
+		})
+		return keeper.(*storage.PostgresStorage)
+	}},
+}
+
+func newTestThrottler(store Store) *Throttler {
+	log := logrus.New()
+	log.SetOutput(io.Discard)
+
+	return &Throttler{
+		cfg: config.Throttle{
+			LoginAttempts: 3,
+			IPAttempts:    100,
+			BaseDelay:     time.Second,
+			MaxLockout:    10 * time.Second,
+			Window:        time.Hour,
+		},
+		store: store,
+		log:   logrus.NewEntry(log),
+	}
+}
+
+func TestDelay(t *testing.T) {
+	th := newTestThrottler(nil)
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{failures: 0, want: 0},
+		{failures: 3, want: 0},
+		{failures: 4, want: time.Second},
+		{failures: 5, want: 2 * time.Second},
+		{failures: 6, want: 4 * time.Second},
+		{failures: 7, want: 8 * time.Second},
+		{failures: 8, want: 10 * time.Second},
+		{failures: 1000, want: 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		if got := th.delay(tt.failures, th.cfg.LoginAttempts); got != tt.want {
+			t.Errorf("delay after %d failures is %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			store := st.newStore(t)
+			th := newTestThrottler(store)
+			ctx := context.Background()
+
+			const key = "login:alice"
+			t0 := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+			reserve := func(now time.Time) (storage.LoginAttempt, bool) {
+				t.Helper()
+
+				attempt, ok, err := th.reserve(ctx, key, th.cfg.LoginAttempts, now)
+				if err != nil {
+					t.Fatalf("reserve: %v", err)
+				}
+				return attempt, ok
+			}
+			failures := func(want int) {
+				t.Helper()
+
+				attempt, err := store.LoginAttempt(ctx, key)
+				if err != nil {
+					t.Fatalf("login attempt: %v", err)
+				}
+				if attempt.Failures != want {
+					t.Errorf("failures are %d, want %d", attempt.Failures, want)
+				}
+			}
+
+			//free attempts
+			for n := 1; n <= th.cfg.LoginAttempts; n++ {
+				if attempt, ok := reserve(t0); !ok || attempt.Failures != n || attempt.LockedUntil.After(t0) {
+					t.Fatalf("attempt %d: ok %t, %+v", n, ok, attempt)
+				}
+			}
+
+			//the next one locks
+			if attempt, ok := reserve(t0); !ok || !attempt.LockedUntil.Equal(t0.Add(time.Second)) {
+				t.Fatalf("locking attempt: ok %t, %+v", ok, attempt)
+			}
+
+			//locked key isn't counted
+			if _, ok := reserve(t0.Add(500 * time.Millisecond)); ok {
+				t.Fatal("attempt of locked key is reserved")
+			}
+			failures(4)
+
+			//lock grows after it ends
+			t1 := t0.Add(time.Second)
+			if attempt, ok := reserve(t1); !ok || !attempt.LockedUntil.Equal(t1.Add(2*time.Second)) {
+				t.Fatalf("attempt after lockout: ok %t, %+v", ok, attempt)
+			}
+			failures(5)
+
+			//attempt which didn't fail is given back, lock stays
+			if err := store.ReleaseLoginAttempt(ctx, key); err != nil {
+				t.Fatalf("release: %v", err)
+			}
+			failures(4)
+			if _, ok := reserve(t1.Add(time.Second)); ok {
+				t.Fatal("release lifted the lockout")
+			}
+
+			//counter starts over out of window
+			if attempt, ok := reserve(t1.Add(2 * time.Hour)); !ok || attempt.Failures != 1 {
+				t.Fatalf("attempt out of window: ok %t, %+v", ok, attempt)
+			}
+
+			if err := store.ResetLoginAttempts(ctx, key); err != nil {
+				t.Fatalf("reset: %v", err)
+			}
+			if _, err := store.LoginAttempt(ctx, key); !errors.Is(err, entities.ErrNotFound) {
+				t.Errorf("reset attempt is found: %v", err)
+			}
+		})
+	}
+}
+
+func TestParallelAttempts(t *testing.T) {
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			th := newTestThrottler(st.newStore(t))
+
+			const parallel = 20
+
+			var (
+				wg      sync.WaitGroup
+				mu      sync.Mutex
+				allowed int
+				locking int
+			)
+
+			for n := 0; n < parallel; n++ {
+				wg.Add(1)
+				go func(n int) {
+					defer wg.Done()
+
+					attempt, err := th.Reserve(context.Background(), "alice", fmt.Sprintf("203.0.113.%d", n))
+					if err != nil {
+						t.Errorf("reserve: %v", err)
+						return
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+
+					if attempt.Wait == 0 {
+						allowed++
+					}
+					if attempt.Lock > 0 {
+						locking++
+					}
+				}(n)
+			}
+			wg.Wait()
+
+			//free attempts and the one which locks
+			if allowed != th.cfg.LoginAttempts+1 || locking != 1 {
+				t.Errorf("%d attempts allowed and %d locking, want %d and 1", allowed, locking, th.cfg.LoginAttempts+1)
+			}
+		})
+	}
+}
+
+func TestSucceed(t *testing.T) {
+	for _, st := range stores {
+		t.Run(st.name, func(t *testing.T) {
+			store := st.newStore(t)
+			th := newTestThrottler(store)
+			ctx := context.Background()
+
+			for n := 0; n < 2; n++ {
+				attempt, err := th.Reserve(ctx, "alice", "203.0.113.1")
+				if err != nil {
+					t.Fatalf("reserve: %v", err)
+				}
+				th.Fail(attempt)
+			}
+
+			attempt, err := th.Reserve(ctx, "alice", "203.0.113.1")
+			if err != nil {
+				t.Fatalf("reserve: %v", err)
+			}
+			if err = th.Succeed(ctx, attempt); err != nil {
+				t.Fatalf("succeed: %v", err)
+			}
+
+			//login is reset, IP keeps its failures
+			if _, err = store.LoginAttempt(ctx, loginKey("alice")); !errors.Is(err, entities.ErrNotFound) {
+				t.Errorf("login attempts are kept: %v", err)
+			}
+
+			ip, err := store.LoginAttempt(ctx, ipKey("203.0.113.1"))
+			if err != nil {
+				t.Fatalf("ip attempts: %v", err)
+			}
+			if ip.Failures != 2 {
+				t.Errorf("ip failures are %d, want 2", ip.Failures)
+			}
+		})
+	}
+}
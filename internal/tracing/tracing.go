@@ -0,0 +1,134 @@
+package tracing
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// ServiceName is service.name of every span
+const ServiceName = "gophermart"
+
+// exporters
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterOTLP   = "otlp"
+	// ExporterMemory keeps spans in memory for tests, see Provider.Spans
+	ExporterMemory = "memory"
+)
+
+var ErrUnknownExporter = errors.New("unknown trace exporter")
+
+// Provider gives tracers to components and propagates W3C trace context
+// through http headers
+type Provider struct {
+	provider   trace.TracerProvider
+	propagator propagation.TextMapPropagator
+	log        *logrus.Entry
+
+	//nil when tracing is disabled
+	sdk    *sdktrace.TracerProvider
+	memory *tracetest.InMemoryExporter
+}
+
+func NewProvider(i do.Injector) (*Provider, error) {
+	p, err := do.InvokeStruct[Provider](i)
+	if err != nil {
+		return nil, errors.Wrap(err, "invoke struct error")
+	}
+
+	cfg := do.MustInvoke[*config.Config](i)
+	p.log = do.MustInvoke[*logger.Logger](i).WithField("component", "tracing")
+	p.propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+	var exporter sdktrace.SpanExporter
+
+	switch cfg.Tracing.Exporter {
+	case "", ExporterNone:
+		//spans are not recorded, incoming trace context is still passed on
+		p.provider = noop.NewTracerProvider()
+		return p, nil
+	case ExporterStdout:
+		exporter, err = stdouttrace.New()
+	case ExporterOTLP:
+		var opts []otlptracehttp.Option
+		if cfg.Tracing.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Tracing.OTLPEndpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	case ExporterMemory:
+		p.memory = tracetest.NewInMemoryExporter()
+	default:
+		return nil, errors.Wrapf(ErrUnknownExporter, "%q", cfg.Tracing.Exporter)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "new %s exporter", cfg.Tracing.Exporter)
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(ServiceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.Tracing.SampleRatio))),
+	}
+
+	//memory spans are visible right after End
+	if p.memory != nil {
+		opts = append(opts, sdktrace.WithSyncer(p.memory))
+	} else {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+
+	p.sdk = sdktrace.NewTracerProvider(opts...)
+	p.provider = p.sdk
+
+	p.log.Infof("tracing enabled, exporter %s", cfg.Tracing.Exporter)
+
+	return p, nil
+}
+
+// Tracer is named by instrumented package path
+func (p *Provider) Tracer(name string) trace.Tracer {
+	return p.provider.Tracer(name)
+}
+
+func (p *Provider) Propagator() propagation.TextMapPropagator {
+	return p.propagator
+}
+
+// Spans are ended spans of memory exporter, nil for other exporters
+func (p *Provider) Spans() tracetest.SpanStubs {
+	if p.memory == nil {
+		return nil
+	}
+
+	return p.memory.GetSpans()
+}
+
+// Shutdown exports buffered spans, it's called by do after all services
+// creating spans are stopped
+func (p *Provider) Shutdown(ctx context.Context) error {
+	if p.sdk == nil {
+		return nil
+	}
+
+	if err := p.sdk.Shutdown(ctx); err != nil {
+		return errors.Wrap(err, "shutdown tracer provider")
+	}
+
+	p.log.Info("tracing stopped")
+
+	return nil
+}
@@ -0,0 +1,111 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+)
+
+// Auditor periodically recomputes balances from the ledger and reports mismatches
+type Auditor struct {
+	cfg     *config.Config
+	storage storage.DataKeeper
+	log     *logrus.Entry
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewAuditor(i do.Injector) (*Auditor, error) {
+	a, err := do.InvokeStruct[Auditor](i)
+	if err != nil {
+		return nil, errors.Wrap(err, "invoke struct error")
+	}
+
+	a.cfg = do.MustInvoke[*config.Config](i)
+	a.log = do.MustInvoke[*logger.Logger](i).WithField("component", "auditor")
+	a.storage = do.MustInvoke[storage.DataKeeper](i)
+
+	return a, nil
+}
+
+func (a *Auditor) Start() {
+	if a.cfg.Ledger.CheckInterval <= 0 {
+		a.log.Warn("ledger check interval is not set, auditor disabled")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	a.cancel = cancel
+
+	a.wg.Add(1)
+	go a.run(ctx)
+}
+
+// Shutdown stops auditor and waits for the current run, it's called by do
+func (a *Auditor) Shutdown() {
+	if a.cancel == nil {
+		return
+	}
+
+	a.cancel()
+	a.wg.Wait()
+
+	a.log.Info("auditor stopped")
+}
+
+func (a *Auditor) run(ctx context.Context) {
+	defer a.wg.Done()
+
+	ticker := time.NewTicker(a.cfg.Ledger.CheckInterval)
+	defer ticker.Stop()
+
+	for {
+		a.check(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (a *Auditor) check(ctx context.Context) {
+	mismatches, err := a.storage.CheckLedger(ctx)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			a.log.Error(errors.Wrap(err, "check ledger"))
+		}
+		return
+	}
+
+	for _, m := range mismatches {
+		if m.TransactionID != 0 {
+			a.log.WithFields(logrus.Fields{
+				"transaction_id": m.TransactionID,
+				"total":          m.Derived.Current,
+			}).Error("unbalanced ledger transaction")
+			continue
+		}
+
+		a.log.WithFields(logrus.Fields{
+			"user_id":          m.UserID,
+			"stored_current":   m.Stored.Current,
+			"stored_withdrawn": m.Stored.Withdrawn,
+			"ledger_current":   m.Derived.Current,
+			"ledger_withdrawn": m.Derived.Withdrawn,
+		}).Error("balance differs from ledger")
+	}
+
+	if len(mismatches) == 0 {
+		a.log.Debug("ledger is consistent")
+	}
+}
@@ -0,0 +1,112 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/samber/do/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/throttle"
+)
+
+// Cleaner periodically removes expired idempotency keys, tokens and
+// stale failed login counters
+type Cleaner struct {
+	cfg      *config.Config
+	storage  storage.DataKeeper
+	throttle *throttle.Throttler
+	log      *logrus.Entry
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewCleaner(i do.Injector) (*Cleaner, error) {
+	c, err := do.InvokeStruct[Cleaner](i)
+	if err != nil {
+		return nil, errors.Wrap(err, "invoke struct error")
+	}
+
+	c.cfg = do.MustInvoke[*config.Config](i)
+	c.log = do.MustInvoke[*logger.Logger](i).WithField("component", "cleaner")
+	c.storage = do.MustInvoke[storage.DataKeeper](i)
+	c.throttle = do.MustInvoke[*throttle.Throttler](i)
+
+	return c, nil
+}
+
+func (c *Cleaner) Start() {
+	if c.cfg.Cleanup.Interval <= 0 {
+		c.log.Warn("cleanup interval is not set, cleaner disabled")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.cancel = cancel
+
+	c.wg.Add(1)
+	go c.run(ctx)
+}
+
+// Shutdown stops cleaner and waits for the current run, it's called by do
+func (c *Cleaner) Shutdown() {
+	if c.cancel == nil {
+		return
+	}
+
+	c.cancel()
+	c.wg.Wait()
+
+	c.log.Info("cleaner stopped")
+}
+
+func (c *Cleaner) run(ctx context.Context) {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(c.cfg.Cleanup.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		c.clean(ctx, time.Now())
+	}
+}
+
+func (c *Cleaner) clean(ctx context.Context, now time.Time) {
+	deleted, err := c.storage.DeleteExpiredIdempotencyKeys(ctx, now)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			c.log.Error(errors.Wrap(err, "delete expired idempotency keys"))
+		}
+	} else if deleted > 0 {
+		c.log.Infof("%d expired idempotency keys deleted", deleted)
+	}
+
+	deleted, err = c.storage.DeleteExpiredTokens(ctx, now)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			c.log.Error(errors.Wrap(err, "delete expired tokens"))
+		}
+	} else if deleted > 0 {
+		c.log.Infof("%d expired tokens deleted", deleted)
+	}
+
+	deleted, err = c.throttle.Cleanup(ctx, now)
+	if err != nil {
+		if !errors.Is(err, context.Canceled) {
+			c.log.Error(errors.Wrap(err, "delete stale login attempts"))
+		}
+	} else if deleted > 0 {
+		c.log.Infof("%d stale login attempts deleted", deleted)
+	}
+}
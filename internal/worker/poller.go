@@ -0,0 +1,256 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/samber/do/v2"
+	"github.com/sirupsen/logrus"
+	"github.com/wickedv43/yd-diploma/internal/accrual"
+	"github.com/wickedv43/yd-diploma/internal/config"
+	"github.com/wickedv43/yd-diploma/internal/entities"
+	"github.com/wickedv43/yd-diploma/internal/logger"
+	"github.com/wickedv43/yd-diploma/internal/metrics"
+	"github.com/wickedv43/yd-diploma/internal/storage"
+	"github.com/wickedv43/yd-diploma/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// batchSize is a count of orders taken from storage per poll
+const batchSize = 100
+
+// AccrualError is a failure of accrual system, not of storage
+type AccrualError struct {
+	Err error
+}
+
+func (e *AccrualError) Error() string {
+	return "accrual system: " + e.Err.Error()
+}
+
+func (e *AccrualError) Unwrap() error {
+	return e.Err
+}
+
+// Poller moves NEW and PROCESSING orders forward using the accrual system
+type Poller struct {
+	cfg     *config.Config
+	storage storage.DataKeeper
+	accrual accrual.Client
+	log     *logrus.Entry
+
+	//orders waiting for accrual and points credited by it
+	backlog prometheus.Gauge
+	accrued prometheus.Counter
+
+	tracer trace.Tracer
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewPoller(i do.Injector) (*Poller, error) {
+	p, err := do.InvokeStruct[Poller](i)
+	if err != nil {
+		return nil, errors.Wrap(err, "invoke struct error")
+	}
+
+	p.cfg = do.MustInvoke[*config.Config](i)
+	p.log = do.MustInvoke[*logger.Logger](i).WithField("component", "poller")
+	p.storage = do.MustInvoke[storage.DataKeeper](i)
+	p.accrual = do.MustInvoke[*accrual.HTTPClient](i)
+
+	p.backlog = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: metrics.Namespace,
+		Name:      "orders_unprocessed",
+		Help:      "Orders in NEW or PROCESSING status, updated every poll.",
+	})
+	p.accrued = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: metrics.Namespace,
+		Name:      "points_accrued_total",
+		Help:      "Points credited for processed orders.",
+	})
+	do.MustInvoke[*metrics.Registry](i).MustRegister(p.backlog, p.accrued)
+
+	p.tracer = do.MustInvoke[*tracing.Provider](i).Tracer("github.com/wickedv43/yd-diploma/internal/worker")
+
+	return p, nil
+}
+
+func (p *Poller) Start() {
+	if p.cfg.AccrualSystem.URL == "" {
+		p.log.Warn("accrual system url is empty, poller disabled")
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+
+	p.wg.Add(1)
+	go p.run(ctx)
+
+	p.log.Info("poller started...")
+}
+
+// Shutdown stops poller and waits for the current run, it's called by do
+func (p *Poller) Shutdown() {
+	if p.cancel == nil {
+		return
+	}
+
+	p.cancel()
+	p.wg.Wait()
+
+	p.log.Info("poller stopped")
+}
+
+func (p *Poller) run(ctx context.Context) {
+	defer p.wg.Done()
+
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		err := p.poll(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			p.log.Error(errors.Wrap(err, "poll"))
+		}
+
+		timer.Reset(p.cfg.AccrualSystem.PollInterval)
+	}
+}
+
+// poll takes a batch of unprocessed orders and checks them with workers
+func (p *Poller) poll(ctx context.Context) error {
+	backlog, err := p.storage.CountUnprocessedOrders(ctx)
+	if err != nil {
+		return errors.Wrap(err, "count unprocessed orders")
+	}
+	p.backlog.Set(float64(backlog))
+
+	orders, err := p.storage.UnprocessedOrders(ctx, batchSize)
+	if err != nil {
+		return errors.Wrap(err, "get unprocessed orders")
+	}
+
+	if len(orders) == 0 {
+		return nil
+	}
+
+	workers := max(p.cfg.AccrualSystem.Workers, 1)
+	jobs := make(chan storage.Order)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for order := range jobs {
+				err := p.check(ctx, order)
+				if err != nil && !errors.Is(err, context.Canceled) {
+					p.log.Error(errors.Wrapf(err, "check order %s", order.Number))
+				}
+			}
+		}()
+	}
+
+feed:
+	for _, order := range orders {
+		select {
+		case <-ctx.Done():
+			break feed
+		case jobs <- order:
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	p.log.WithField("stats", p.accrual.Stats()).Debug("accrual responses")
+
+	return ctx.Err()
+}
+
+// check asks accrual system about order and stores the result
+func (p *Poller) check(ctx context.Context, order storage.Order) error {
+	//one trace per order, accrual call and update are its children
+	ctx, span := p.tracer.Start(ctx, "Poller.check", trace.WithAttributes(attribute.String("order.number", order.Number)))
+	defer span.End()
+
+	resp, err := p.accrual.GetOrder(ctx, order.Number)
+	if err != nil {
+		//not registered yet
+		if errors.Is(err, accrual.ErrNotRegistered) {
+			return nil
+		}
+		return &AccrualError{Err: err}
+	}
+
+	status := mapStatus(resp.Status)
+	if status == "" {
+		return &AccrualError{Err: errors.Errorf("unknown accrual status %q", resp.Status)}
+	}
+
+	//nothing changed
+	if status == order.Status {
+		return nil
+	}
+
+	order.Status = status
+	if resp.Accrual != nil {
+		order.Accrual = *resp.Accrual
+	}
+
+	err = p.storage.UpdateOrder(ctx, order)
+	if err != nil {
+		return errors.Wrap(err, "update order")
+	}
+
+	if order.Status == storage.StatusProcessed {
+		p.accrued.Add(order.Accrual.Float())
+	}
+
+	return nil
+}
+
+// Recheck asks accrual system about the order right away
+func (p *Poller) Recheck(ctx context.Context, number string) (storage.Order, error) {
+	order, err := p.storage.Order(ctx, number)
+	if err != nil {
+		return storage.Order{}, errors.Wrap(err, "get order")
+	}
+
+	//final statuses never change
+	if order.Status == storage.StatusInvalid || order.Status == storage.StatusProcessed {
+		return order, entities.ErrConflict
+	}
+
+	if err = p.check(ctx, order); err != nil {
+		return storage.Order{}, err
+	}
+
+	return p.storage.Order(ctx, number)
+}
+
+// mapStatus converts accrual system status into order status
+func mapStatus(status string) string {
+	switch status {
+	case accrual.StatusRegistered, accrual.StatusProcessing:
+		return storage.StatusProcessing
+	case accrual.StatusInvalid:
+		return storage.StatusInvalid
+	case accrual.StatusProcessed:
+		return storage.StatusProcessed
+	}
+
+	return ""
+}